@@ -4,6 +4,7 @@ import (
 	"4d63.com/tz"
 	"context"
 	"fmt"
+	"github.com/moskvorechie/log1c/spool"
 	"github.com/moskvorechie/logs"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
@@ -11,30 +12,55 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"regexp"
 	"runtime"
 	"runtime/debug"
 	"runtime/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type App struct {
+	Options Options
+
+	backfill Backfill
 	loc      *time.Location
-	regex1   *regexp.Regexp
 	logger   logs.Log
 	cfg      *ini.File
 	exit     chan bool
 	mess     chan Message
+	queue    *spool.Queue
 	wg       *sync.WaitGroup
+	rwg      *sync.WaitGroup
+	senders  map[string]*runningSender
+	running  map[string]*runningSource
 	name     string
 	root     string
 	instance string
+
+	// Config and sources are replaced on reload, pristine is config as it is
+	// in file because Must* of ini writes defaults to keys
+	pristine      *ini.File
+	cfgMu         sync.RWMutex
+	reloadMu      sync.Mutex
+	runMu         sync.Mutex
+	sourcesMu     sync.RWMutex
+	sources       map[string]*Source
+	discovering   bool
+	discoveryWake chan struct{}
+
+	// State of readers and senders for /status
+	stateMu      sync.Mutex
+	sourceStates map[string]*sourceState
+	outputStates map[string]*outputState
+	started      int32
+	startTime    time.Time
 }
 
 func (a *App) Start() {
 
 	var err error
+	a.startTime = time.Now()
 
 	root, _ := os.Getwd()
 	root += string(os.PathSeparator)
@@ -45,6 +71,10 @@ func (a *App) Start() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	a.pristine, err = ini.Load(a.root + "app.ini")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Name
 	a.name = a.cfg.Section("main").Key("app").String()
@@ -61,7 +91,7 @@ func (a *App) Start() {
 	}
 
 	// Set log level
-	a.setLogLevel()
+	a.setLogLevel(a.cfg)
 
 	// Exit on error
 	defer func() {
@@ -74,51 +104,83 @@ func (a *App) Start() {
 
 	a.pprof()
 
-	// Prepare regex
-	a.regex1 = regexp.MustCompile(`(?mis){(\d+),(\w),\s+?{(\w+),(\w+)},(\d+),(\d+),(\d+),(\d+),(\d+),(\w+),"(.*)?",(\d+),\s+?{"(\w)",?(.*)?},"(.*)?",(\d+),(\d+),(\d+),(\d+),(\d+),([\d,]+)?,?\s+?{\d(.*)?}\s+?},?`)
+	// Time zone of log records
+	a.loc, err = loadLocation(a.cfg.Section("main").Key("timezone").MustString("Europe/Moscow"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Backfill
+	a.backfill, err = a.loadBackfill()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Loc
-	loc, err := tz.LoadLocation("Europe/Moscow")
+	// Log dirs with settings
+	sources, err := a.sourceConfigs(a.cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	a.loc = loc
+	a.sources = make(map[string]*Source, len(sources))
+	for _, src := range sources {
+		a.sources[src.Name] = src
+	}
+	outputs := a.outputConfigs(a.cfg)
+	if err := checkSourceOutputs(sources, outputs); err != nil {
+		log.Fatal(err)
+	}
 
 	// Sync
+	a.sourceStates = make(map[string]*sourceState)
+	a.outputStates = make(map[string]*outputState)
 	a.wg = &sync.WaitGroup{}
+	a.rwg = &sync.WaitGroup{}
 
 	// Send
 	a.exit = make(chan bool)
 	a.mess = make(chan Message, 100)
 
-	// Start watch each log dir in separate goroutine
-	section := a.cfg.Section("logs")
-	for k, flog := range section.Keys() {
-
-		// Run Sender
-		a.wg.Add(1)
-		var s Sender
-		s.logger = a.logger
-		s.mess = a.mess
-		s.cfg = a.cfg
-		s.wg = a.wg
-		go s.Run(k)
-
-		// Run DirReader
-		a.wg.Add(1)
-		var r DirReader
-		r.app = a
-		r.wg = a.wg
-		r.exit = a.exit
-		r.cfg = a.cfg
-		r.logger = a.logger
-		r.name = flog.Name()
-		r.path = flog.String()
-		go r.Run()
-	}
-
-	// Server for metrics
+	// Disk queue between readers and sender
+	if err := a.openSpool(); err != nil {
+		log.Fatal(err)
+	}
+	a.wg.Add(1)
+	go a.runSpool()
+
+	// Run Sender for each output
+	a.senders = make(map[string]*runningSender)
+	for _, oc := range outputs {
+		output, err := newOutput(oc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := a.startSender(oc, output); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Start watch each log dir in separate goroutine, history is loaded only now
+	a.running = make(map[string]*runningSource)
+	for _, src := range sources {
+		a.startSource(src, startBackfill)
+	}
+
+	// Bases of cluster registry which are not set in config
+	a.discoveryWake = make(chan struct{}, 1)
+	if a.cfg.Section("discovery").Key("enabled").MustBool() {
+		a.startDiscovery()
+	}
+
+	// Reload config on SIGHUP
+	go a.watchReload()
+	atomic.StoreInt32(&a.started, 1)
+
+	// Server for metrics and state
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/reload", a.reloadHandler)
+	http.HandleFunc("/healthz", a.healthHandler)
+	http.HandleFunc("/readyz", a.readyHandler)
+	http.HandleFunc("/status", a.statusHandler)
 	addr := "0.0.0.0:54545"
 	if a.name == "test" {
 		addr = "0.0.0.0:80"
@@ -142,22 +204,105 @@ func (a *App) Start() {
 }
 
 func (a *App) Stop() error {
+
+	// Reload is not started after exit
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
 	close(a.exit)
+
+	// Readers first, then spool writes the rest of messages
+	a.runMu.Lock()
+	for _, rs := range a.running {
+		close(rs.exit)
+	}
+	a.runMu.Unlock()
+	a.rwg.Wait()
 	close(a.mess)
+	for _, rs := range a.senders {
+		close(rs.exit)
+	}
 	a.wg.Wait()
+	if err := a.queue.Close(); err != nil {
+		a.logger.LogError(err)
+	}
+
+	// Save positions acknowledged after readers stop
+	a.runMu.Lock()
+	for _, rs := range a.running {
+		rs.save()
+	}
+	a.runMu.Unlock()
 	return nil
 }
 
-func (a *App) setLogLevel() {
-	switch a.cfg.Section("main").Key("log_level").String() {
+// Current config, it is replaced on reload
+func (a *App) config() *ini.File {
+	a.cfgMu.RLock()
+	defer a.cfgMu.RUnlock()
+	return a.cfg
+}
+
+// Reader of event log dir, exit stops only this reader
+func (a *App) newDirReader(src *Source, exit chan bool) *DirReader {
+	cfg := a.config()
+	r := &DirReader{}
+	r.app = a
+	r.wg = a.rwg
+	r.exit = exit
+	r.cfg = cfg
+	r.logger = a.logger
+	r.name = src.Name
+	r.path = src.Path
+	r.loc = src.Loc
+	r.cp = newCheckpointStore(a.root + cfg.Section("main").Key("checkpoint_path").MustString("checkpoints") + string(os.PathSeparator) + r.name + ".json")
+	return r
+}
+
+// Technological journal dir with process dirs
+func (a *App) newTechLogReader(src *Source, exit chan bool) *TechLogReader {
+	cfg := a.config()
+	r := &TechLogReader{}
+	r.app = a
+	r.wg = a.rwg
+	r.exit = exit
+	r.cfg = cfg
+	r.logger = a.logger
+	r.name = src.Name
+	r.path = src.Path
+	r.loc = src.Loc
+	r.cpDir = a.root + cfg.Section("main").Key("checkpoint_path").MustString("checkpoints")
+	return r
+}
+
+// Time zone of source from [timezone] section, [main] timezone if it is not set
+func (a *App) sourceLocation(cfg *ini.File, name string) (*time.Location, error) {
+	zone := cfg.Section("timezone").Key(name).String()
+	if zone == "" {
+		zone = cfg.Section("main").Key("timezone").MustString("Europe/Moscow")
+	}
+	return loadLocation(zone)
+}
+
+// Zones are embedded, Windows has no zoneinfo
+func loadLocation(zone string) (*time.Location, error) {
+	loc, err := tz.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone %q: %v", zone, err)
+	}
+	return loc, nil
+}
+
+// Level is global, so loggers of running readers get it on reload too
+func (a *App) setLogLevel(cfg *ini.File) {
+	switch cfg.Section("main").Key("log_level").String() {
 	case "info":
-		a.logger.SetCustomLogger(a.logger.Logger().Level(zerolog.InfoLevel))
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	case "warning":
-		a.logger.SetCustomLogger(a.logger.Logger().Level(zerolog.WarnLevel))
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
 	case "error":
-		a.logger.SetCustomLogger(a.logger.Logger().Level(zerolog.ErrorLevel))
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 	default:
-		a.logger.SetCustomLogger(a.logger.Logger().Level(zerolog.DebugLevel))
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 }
 
@@ -0,0 +1,242 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Bytes from file beginning used as file identity
+const identityLen = 1024
+
+// Checkpoint is a position right after the last record of file,
+// which was acknowledged by sender
+type Checkpoint struct {
+	Path        string
+	Offset      int64
+	Identity    string
+	IdentityLen int64
+	RecordHash  string
+	RecordLen   int64
+}
+
+type checkpointMark struct {
+	cp     Checkpoint
+	done   bool
+	failed bool
+}
+
+// Tracks read records of one source and saves position of the last record
+// after which all records are acknowledged
+type checkpointStore struct {
+	mu        sync.Mutex
+	file      string
+	committed Checkpoint
+	pending   []*checkpointMark
+	stuck     bool
+	dirty     bool
+}
+
+// Store without file keeps position in memory only, like for log1c tail
+func newCheckpointStore(file string) *checkpointStore {
+	return &checkpointStore{file: file}
+}
+
+// Register record sent to sender, mark must be acknowledged
+func (s *checkpointStore) track(cp Checkpoint) *checkpointMark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stuck {
+		return nil
+	}
+	m := &checkpointMark{cp: cp}
+	s.pending = append(s.pending, m)
+	return m
+}
+
+// Register record which will not be sent
+func (s *checkpointStore) skip(cp Checkpoint) {
+	s.ack(s.track(cp))
+}
+
+func (s *checkpointStore) ack(m *checkpointMark) {
+	if m == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.done = true
+	s.commit()
+}
+
+// Record was not delivered, position can not move further until restart
+func (s *checkpointStore) fail(m *checkpointMark) {
+	if m == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.failed = true
+	s.commit()
+}
+
+func (s *checkpointStore) commit() {
+	i := 0
+	for ; i < len(s.pending) && s.pending[i].done; i++ {
+		s.committed = s.pending[i].cp
+		s.dirty = true
+	}
+	s.pending = s.pending[i:]
+	if len(s.pending) > 0 && s.pending[0].failed {
+		s.stuck = true
+		s.pending = nil
+	}
+}
+
+func (s *checkpointStore) get() Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.committed
+}
+
+// Wait until all tracked records are acknowledged or failed, false on timeout
+func (s *checkpointStore) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		s.mu.Unlock()
+		if n == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Save committed checkpoint to file through temp file and rename
+func (s *checkpointStore) save() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	cp := s.committed
+	s.dirty = false
+	s.mu.Unlock()
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// Load saved checkpoint, ok is false if there is nothing saved yet
+func (s *checkpointStore) load() (cp Checkpoint, ok bool, err error) {
+	if s.file == "" {
+		return cp, false, nil
+	}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return cp, false, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.committed = cp
+	s.mu.Unlock()
+	return cp, true, nil
+}
+
+// Check that checkpoint points to the same file and record
+func (cp Checkpoint) verify() error {
+
+	// Offset of .lgd is rowID
+	if isLGD(cp.Path) {
+		return verifyLGD(cp)
+	}
+
+	file, err := os.Open(cp.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < cp.Offset {
+		return fmt.Errorf("file %s is shorter than checkpoint offset %d", cp.Path, cp.Offset)
+	}
+
+	identity, _, err := fileIdentity(file, cp.IdentityLen)
+	if err != nil {
+		return err
+	}
+	if identity != cp.Identity {
+		return fmt.Errorf("file %s was replaced", cp.Path)
+	}
+
+	if cp.RecordLen <= 0 {
+		return nil
+	}
+	raw := make([]byte, cp.RecordLen)
+	if _, err := file.ReadAt(raw, cp.Offset-cp.RecordLen); err != nil {
+		return err
+	}
+	if recordHash(raw) != cp.RecordHash {
+		return fmt.Errorf("file %s has another record before offset %d", cp.Path, cp.Offset)
+	}
+
+	return nil
+}
+
+// Hash of first n bytes of file, n is decreased if file is shorter
+func fileIdentity(file io.ReaderAt, n int64) (string, int64, error) {
+	buf := make([]byte, n)
+	read, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	buf = buf[:read]
+	return fmt.Sprintf("%x", sha256.Sum256(buf)), int64(read), nil
+}
+
+func recordHash(raw []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rs/zerolog"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Command runs from console instead of service, like log1c parse <dir>.
+// Init sets flags of command and returns function run with other arguments.
+type command struct {
+	usage string
+	init  func(fs *flag.FlagSet) func(args []string) error
+}
+
+var commands = map[string]command{
+	"parse":        {"[flags] <dir|file.lgp>...", parseCommand},
+	"tail":         {"[flags] <dir>", tailCommand},
+	"check-config": {"[flags]", checkCommand},
+}
+
+// IsCommand tells if first argument is command and not flag of service
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// RunCommand runs command with its arguments and returns exit code,
+// it is 2 for wrong arguments and 1 for other errors
+func RunCommand(args []string) int {
+
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, commands: %v\n", name, commandNames())
+		return 2
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	usage := func() {
+		fs.SetOutput(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Usage: log1c %s %s\n", name, cmd.usage)
+		fs.PrintDefaults()
+	}
+	run := cmd.init(fs)
+
+	err := fs.Parse(args[1:])
+	if err == flag.ErrHelp {
+		usage()
+		return 0
+	}
+	if err == nil {
+		err = run(fs.Args())
+	} else {
+		err = usageError(err.Error())
+	}
+
+	var ue usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ue):
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		usage()
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	return 1
+}
+
+// App without config file for readers of commands, their log goes to stderr
+func newConsoleApp() *App {
+	a := &App{}
+	a.cfg = ini.Empty()
+	a.logger.SetCustomLogger(zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger())
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	a.exit = make(chan bool)
+	a.mess = make(chan Message, 100)
+	a.wg = &sync.WaitGroup{}
+	a.rwg = &sync.WaitGroup{}
+	a.sources = make(map[string]*Source)
+	a.sourceStates = make(map[string]*sourceState)
+	a.outputStates = make(map[string]*outputState)
+	return a
+}
+
+func commandNames() (list []string) {
+	for name := range commands {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return
+}
+
+// Wrong arguments, usage of command is printed after it
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Lines of check-config, failed checks are counted for exit code
+type checkReport struct {
+	w      io.Writer
+	failed int
+}
+
+func (r *checkReport) add(name string, err error, format string, args ...interface{}) {
+	if err != nil {
+		r.failed++
+		fmt.Fprintf(r.w, "FAIL  %s: %v\n", name, err)
+		return
+	}
+	fmt.Fprintf(r.w, "OK    %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+// log1c check-config reads app.ini like service does on start and checks
+// sources, outputs and dirs of service without starting it
+func checkCommand(fs *flag.FlagSet) func(args []string) error {
+
+	config := fs.String("config", "app.ini", "path of app.ini, relative paths of config are resolved from its dir")
+	offline := fs.Bool("offline", false, "do not connect to outputs")
+
+	return func(args []string) error {
+
+		if len(args) != 0 {
+			return usageError("unexpected arguments")
+		}
+		path, err := filepath.Abs(*config)
+		if err != nil {
+			return err
+		}
+
+		a := newConsoleApp()
+		a.root = filepath.Dir(path) + string(os.PathSeparator)
+		report := &checkReport{w: os.Stdout}
+		a.checkConfig(path, !*offline, report)
+
+		if report.failed > 0 {
+			return fmt.Errorf("%d checks failed", report.failed)
+		}
+		return nil
+	}
+}
+
+func (a *App) checkConfig(path string, online bool, report *checkReport) {
+
+	var err error
+	a.cfg, err = ini.Load(path)
+	report.add("config", err, "%s", path)
+	if err != nil {
+		return
+	}
+	a.name = a.cfg.Section("main").Key("app").String()
+	main := a.cfg.Section("main")
+
+	a.loc, err = loadLocation(main.Key("timezone").MustString("Europe/Moscow"))
+	report.add("timezone", err, "%s", a.loc)
+	if a.loc != nil {
+		a.backfill, err = a.loadBackfill()
+		report.add("backfill", err, "enabled %v", a.backfill.Enabled)
+	}
+
+	// Dirs written by service
+	dirs := []struct {
+		name string
+		dir  string
+	}{
+		{"checkpoints", main.Key("checkpoint_path").MustString("checkpoints")},
+		{"spool", a.cfg.Section("spool").Key("path").MustString("spool")},
+	}
+	for _, d := range dirs {
+		dir := d.dir
+		if !filepath.IsAbs(dir) {
+			dir = a.root + dir
+		}
+		report.add(d.name, checkWritable(dir), "%s is writable", dir)
+	}
+
+	// Sources, every section is checked even if others are wrong
+	failed := 0
+	sources := a.readSources(a.cfg, func(name string, err error) {
+		failed++
+		report.add("source "+name, err, "")
+	})
+	for _, src := range sources {
+		a.sources[src.Name] = src
+		info, err := checkSourceDir(src)
+		report.add("source "+src.Name, err, "%s %s, %s", src.Format, src.Path, info)
+	}
+
+	// Outputs, endpoints are checked with credentials of output
+	outputs := a.outputConfigs(a.cfg)
+	if failed == 0 {
+		report.add("routes", checkSourceOutputs(sources, outputs), "outputs of sources are set")
+	}
+	for _, oc := range outputs {
+		name := "output " + oc.Name
+		output, err := newOutput(oc)
+		if err != nil {
+			report.add(name, err, "")
+			continue
+		}
+		checker, ok := output.(Checker)
+		if !online || !ok {
+			report.add(name, nil, "%s, not checked", oc.Type)
+			continue
+		}
+		start := time.Now()
+		err = checker.Check()
+		report.add(name, err, "%s is available, %s", oc.Type, time.Since(start).Round(time.Millisecond))
+	}
+
+	// Cluster registry
+	if section := a.cfg.Section("discovery"); section.Key("enabled").MustBool() {
+		srvinfo := section.Key("path").MustString(`C:\Program Files\1cv8\srvinfo`)
+		bases, err := discoverInfobases(srvinfo)
+		report.add("discovery", err, "%s, %d infobases with event log", srvinfo, len(bases))
+	}
+}
+
+// Files of source which are needed to start its reader
+func checkSourceDir(src *Source) (info string, err error) {
+	if src.Format == formatTechLog {
+		n, err := checkTechLogDir(src.Path)
+		return fmt.Sprintf("%d process dirs", n), err
+	}
+	return checkEventLogDir(src.Path)
+}
+
+// Dictionary and at least one log file are needed to start reader
+func checkEventLogDir(dir string) (info string, err error) {
+
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return
+	}
+	if !stat.IsDir() {
+		return "", fmt.Errorf("%s is not dir", dir)
+	}
+
+	r := &DirReader{path: dir}
+	if lgd := r.lgdPath(); lgd != "" {
+		row, err := lgdLastRow(lgd)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", lgd, err)
+		}
+		return fmt.Sprintf("%s, %d rows", lgdName, row), nil
+	}
+
+	meta, err := loadDictionaryFile(filepath.Join(dir, "1Cv8.lgf"))
+	if err != nil {
+		return
+	}
+	files, err := r.listFiles()
+	if err != nil {
+		return
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("%s: no .lgp files", dir)
+	}
+	return fmt.Sprintf("dictionary %d items, %d .lgp files", meta.Len(), len(files)), nil
+}
+
+// Process dirs appear when 1C starts writing journal, empty dir is fine
+func checkTechLogDir(dir string) (n int, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			n++
+		}
+	}
+	return
+}
+
+// Dir or its nearest existing parent allows to create files, dir itself is not created
+func checkWritable(dir string) error {
+	for {
+		stat, err := os.Stat(dir)
+		if err == nil {
+			if !stat.IsDir() {
+				return fmt.Errorf("%s is not dir", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	file, err := ioutil.TempFile(dir, ".log1c-check")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
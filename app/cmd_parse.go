@@ -0,0 +1,301 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/moskvorechie/log1c/lgp"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields printed by parse if -fields is not set
+var parseFields = []string{
+	"ДатаВремя", "Level", "Пользователь", "Компьютер", "Приложение", "Событие", "Метаданные",
+	"Комментарий", "Данные", "Представление", "Сервер", "Соединение", "Сеанс",
+	"СтатусТранзакции", "НомерТранзакции",
+}
+
+// Events printed by parse and tail
+type eventFilter struct {
+	from   time.Time
+	to     time.Time
+	level  string
+	users  []string
+	events []string
+}
+
+// log1c parse prints events of .lgp files as NDJSON or CSV
+func parseCommand(fs *flag.FlagSet) func(args []string) error {
+
+	format := fs.String("format", "ndjson", "output format: ndjson or csv")
+	from := fs.String("from", "", "events from, YYYY-MM-DD or YYYY-MM-DD hh:mm:ss")
+	to := fs.String("to", "", "events before, date only value includes whole day")
+	fields := fs.String("fields", strings.Join(parseFields, ","), "comma separated fields of output")
+	dict := fs.String("dict", "", "path of 1Cv8.lgf, by default it is in dir of .lgp files")
+	filter := newFilterFlags(fs)
+
+	return func(args []string) (err error) {
+
+		if len(args) == 0 {
+			return usageError("dir or file is not set")
+		}
+		if *format != "ndjson" && *format != "csv" {
+			return usageError(fmt.Sprintf("unknown format %q", *format))
+		}
+		columns := splitList(*fields)
+		for _, name := range columns {
+			if !isMessageField(name) {
+				return usageError(fmt.Sprintf("message has no field %s", name))
+			}
+		}
+
+		f, loc, err := filter.parse()
+		if err != nil {
+			return err
+		}
+		if f.from, err = parseDate(*from, loc, false); err != nil {
+			return usageError(err.Error())
+		}
+		if f.to, err = parseDate(*to, loc, true); err != nil {
+			return usageError(err.Error())
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		pw := newParseWriter(out, *format, columns)
+		for _, arg := range args {
+			files, lgf, err := parseFiles(arg)
+			if err != nil {
+				return err
+			}
+			if *dict != "" {
+				lgf = *dict
+			}
+			meta, err := loadDictionaryFile(lgf)
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				if err := parseFile(path, meta, loc, &f, pw); err != nil {
+					return err
+				}
+			}
+		}
+		if err := pw.Flush(); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+}
+
+// Flags of filter and time zone, they are the same in parse and tail
+type filterFlags struct {
+	zone  *string
+	level *string
+	user  *string
+	event *string
+}
+
+func newFilterFlags(fs *flag.FlagSet) *filterFlags {
+	return &filterFlags{
+		zone:  fs.String("tz", "Europe/Moscow", "time zone of server which wrote log"),
+		level: fs.String("level", "debug", "min level: debug, info, warning or error"),
+		user:  fs.String("user", "", "users, comma separated glob patterns, case is ignored"),
+		event: fs.String("event", "", "events, comma separated glob patterns like _$Data$_.*"),
+	}
+}
+
+func (ff *filterFlags) parse() (f eventFilter, loc *time.Location, err error) {
+	switch *ff.level {
+	case "debug", "info", "warning", "error":
+	default:
+		return f, nil, usageError(fmt.Sprintf("unknown level %q", *ff.level))
+	}
+	if loc, err = loadLocation(*ff.zone); err != nil {
+		return f, nil, usageError(err.Error())
+	}
+	f = eventFilter{level: *ff.level, users: splitList(*ff.user), events: splitList(*ff.event)}
+	return
+}
+
+// Files of dir in order of creation and dictionary next to them
+func parseFiles(arg string) (files []string, lgf string, err error) {
+
+	stat, err := os.Stat(arg)
+	if err != nil {
+		return
+	}
+	if !stat.IsDir() {
+		if isLGD(arg) {
+			return nil, "", fmt.Errorf("%s: .lgd format is not supported", arg)
+		}
+		return []string{arg}, filepath.Join(filepath.Dir(arg), "1Cv8.lgf"), nil
+	}
+
+	r := &DirReader{path: arg}
+	if files, err = r.listFiles(); err != nil {
+		return
+	}
+	if len(files) == 0 {
+		if r.lgdPath() != "" {
+			return nil, "", fmt.Errorf("%s: .lgd format is not supported", arg)
+		}
+		return nil, "", fmt.Errorf("%s: no .lgp files", arg)
+	}
+	return files, filepath.Join(arg, "1Cv8.lgf"), nil
+}
+
+func loadDictionaryFile(path string) (*lgp.Dictionary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return lgp.LoadDictionary(file)
+}
+
+// Broken records are reported and skipped, unfinished record at the end is being written by 1C
+func parseFile(path string, meta *lgp.Dictionary, loc *time.Location, f *eventFilter, pw *parseWriter) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rd := lgp.NewReader(file)
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if _, ok := err.(*lgp.RecordError); ok {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		m, err := recordMessage(rec, meta, loc, string(rd.Raw()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v at offset %d\n", path, err, rd.Start())
+			continue
+		}
+		if !f.allow(&m) {
+			continue
+		}
+		if err := pw.Write(&m); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *eventFilter) allow(m *Message) bool {
+	if !f.from.IsZero() && m.ДатаВремя.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !m.ДатаВремя.Before(f.to) {
+		return false
+	}
+	if !levelAllowed(f.level, m.Level) {
+		return false
+	}
+	if len(f.users) > 0 && !matchAny(m.Пользователь, f.users) {
+		return false
+	}
+	if len(f.events) > 0 && !matchAny(m.Событие, f.events) {
+		return false
+	}
+	return true
+}
+
+// Prints fields of messages as JSON lines or CSV rows with header
+type parseWriter struct {
+	w      io.Writer
+	csv    *csv.Writer
+	fields []string
+}
+
+func newParseWriter(w io.Writer, format string, fields []string) *parseWriter {
+	pw := &parseWriter{w: w, fields: fields}
+	if format == "csv" {
+		pw.csv = csv.NewWriter(w)
+		pw.csv.Write(fields)
+	}
+	return pw
+}
+
+func (pw *parseWriter) Write(m *Message) error {
+
+	v := reflect.ValueOf(m).Elem()
+
+	if pw.csv != nil {
+		row := make([]string, len(pw.fields))
+		for i, name := range pw.fields {
+			row[i] = csvValue(v.FieldByName(name).Interface())
+		}
+		return pw.csv.Write(row)
+	}
+
+	// Fields keep order of -fields
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range pw.fields {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		value, err := json.Marshal(v.FieldByName(name).Interface())
+		if err != nil {
+			return err
+		}
+		sb.WriteString(strconv.Quote(name))
+		sb.WriteByte(':')
+		sb.Write(value)
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(pw.w, sb.String())
+	return err
+}
+
+func (pw *parseWriter) Flush() error {
+	if pw.csv == nil {
+		return nil
+	}
+	pw.csv.Flush()
+	return pw.csv.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case *lgp.Value:
+		if v == nil {
+			return ""
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Comma separated list without empty items
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Escape codes of terminal colors
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+)
+
+// log1c tail follows event log dir like tail -f, records are read by the same
+// DirReader as in service but printed instead of sending
+func tailCommand(fs *flag.FlagSet) func(args []string) error {
+
+	all := fs.Bool("all", false, "print log from the beginning of the oldest file, not only new events")
+	color := fs.Bool("color", isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" && enableColors(os.Stdout), "colorize levels, users and events")
+	filter := newFilterFlags(fs)
+
+	return func(args []string) error {
+
+		if len(args) != 1 {
+			return usageError("one event log dir is expected")
+		}
+		f, loc, err := filter.parse()
+		if err != nil {
+			return err
+		}
+		dir := args[0]
+		if stat, err := os.Stat(dir); err != nil {
+			return err
+		} else if !stat.IsDir() {
+			return fmt.Errorf("%s is not dir", dir)
+		}
+		if !hasEventLog(dir) {
+			return fmt.Errorf("%s: no event log, 1Cv8.lgf with .lgp files or 1Cv8.lgd is expected", dir)
+		}
+
+		// Files are polled often, first events of -all are not waited for long
+		a := newConsoleApp()
+		a.cfg.Section("main").Key("watch_interval").SetValue("1s")
+		src := &Source{Name: "tail", Path: dir, Format: formatEventLog, Loc: loc, MinLevel: "debug"}
+		a.setSource(src)
+
+		// Position is not saved, every run starts from the end
+		r := a.newDirReader(src, a.exit)
+		r.cp = newCheckpointStore("")
+		r.fromStart = *all
+		a.rwg.Add(1)
+		go r.Run()
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		go func() {
+			<-sig
+			close(a.exit)
+		}()
+		done := make(chan struct{})
+		go func() {
+			a.rwg.Wait()
+			close(done)
+		}()
+
+		for {
+			select {
+			case <-done:
+				return nil
+			case m := <-a.mess:
+				m.ack()
+				if f.allow(&m) {
+					printTail(os.Stdout, &m, *color)
+				}
+			}
+		}
+	}
+}
+
+// Line of event: time, level, user, event, metadata and comment, lines of comment are indented
+func printTail(w io.Writer, m *Message, color bool) {
+
+	paint := func(code, s string) string {
+		if !color || s == "" {
+			return s
+		}
+		return code + s + colorReset
+	}
+
+	level := strings.ToUpper(m.Level)
+	levelColor := colorGray
+	switch m.Level {
+	case "error":
+		levelColor = colorRed
+	case "warning":
+		levelColor = colorYellow
+	case "info":
+		levelColor = colorGreen
+	}
+
+	user := m.Пользователь
+	if user == "" {
+		user = "-"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(paint(colorGray, m.ДатаВремя.Format("2006-01-02 15:04:05")))
+	sb.WriteByte(' ')
+	sb.WriteString(paint(levelColor, fmt.Sprintf("%-7s", level)))
+	sb.WriteByte(' ')
+	sb.WriteString(paint(colorCyan, user))
+	sb.WriteByte(' ')
+	sb.WriteString(paint(colorBold, m.Событие))
+	if m.Метаданные != "" {
+		sb.WriteString(" [" + m.Метаданные + "]")
+	}
+	if comment := strings.TrimSpace(m.Комментарий); comment != "" {
+		comment = strings.ReplaceAll(comment, "\r\n", "\n")
+		sb.WriteByte(' ')
+		sb.WriteString(strings.ReplaceAll(comment, "\n", "\n    "))
+	}
+	sb.WriteByte('\n')
+	io.WriteString(w, sb.String())
+}
+
+// Colors are off if output is redirected to file
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import "os"
+
+// Terminals print escape codes as is
+func enableColors(f *os.File) bool {
+	return true
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package app
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// Console of Windows prints escape codes only in virtual terminal mode,
+// older consoles do not support it and colors are off
+func enableColors(f *os.File) bool {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
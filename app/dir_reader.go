@@ -1,14 +1,18 @@
 package app
 
 import (
+	"fmt"
+	"github.com/moskvorechie/log1c/lgp"
 	"github.com/moskvorechie/logs"
 	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/ini.v1"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,7 +26,30 @@ type DirReader struct {
 	name   string
 	path   string
 	app    *App
-	meta   Meta
+	meta   *lgp.Dictionary
+	cp     *checkpointStore
+	bcp    *checkpointStore
+	state  *sourceState
+	from   time.Time
+	to     time.Time
+	loc    *time.Location
+
+	// Dir appeared after start, it is read from the beginning
+	fromStart bool
+
+	// Reader started with service loads history of [backfill] first
+	backfill bool
+
+	// Dictionary is read from .lgf incrementally
+	metaMu          sync.RWMutex
+	metaPos         int64
+	metaIdentity    string
+	metaIdentityLen int64
+	metaChecked     time.Time
+
+	// Dictionary of .lgd is read by codes after the last read ones
+	metaFile  os.FileInfo
+	metaCodes map[lgp.ItemType]int64
 }
 
 var (
@@ -49,8 +76,9 @@ func (r *DirReader) Run() {
 
 	defer r.wg.Done()
 
-	// Set app to logger
-	r.logger.SetCustomLogger(r.logger.Logger().With().Str("log_name", r.name).Logger())
+	// Set app to logger, errors are shown by /status
+	r.state = r.app.sourceState(r.name)
+	r.logger.SetCustomLogger(r.logger.Logger().With().Str("log_name", r.name).Logger().Hook(r.state.hook()))
 
 	// Start & stop log
 	r.logger.Info("DirReader start")
@@ -58,65 +86,223 @@ func (r *DirReader) Run() {
 
 	appName := r.cfg.Section("main").Key("app").String()
 
-	// Parse metadata
-	filePath, pos := r.prepare("", 0)
+	// Load history before tailing
+	var filePath string
+	var pos int64
+	if r.backfill {
+		var tail bool
+		filePath, pos, tail = r.loadHistory()
+		if !tail {
+			return
+		}
+	}
+	defer r.saveCheckpoint()
+
+	// Wake on writes to dir
+	w := r.app.newWatcher(r.path)
+	defer w.Close()
+
+	// Dir could be removed with its infobase, reader waits until it is
+	// stopped by discovery or reload, or files appear again
+	if filePath == "" {
+		var err error
+		for filePath, pos, err = r.start(); err != nil; filePath, pos, err = r.start() {
+			r.logger.ErrorF("Start error: %v", err)
+			if _, ok := w.Wait(r.exit); !ok {
+				return
+			}
+		}
+	}
+	r.state.position("", filePath, pos)
 
 	// Read files forever
 	for {
+		rescan, ok := w.Wait(r.exit)
+		if !ok {
+			return
+		}
+
+		// Parse metadata
+		if err := r.parseMetadata(); err != nil {
+			r.logger.ErrorF("Metadata error: %v", err)
+			continue
+		}
+
+		// Metric read time >
+		tReadDurStart := time.Now()
+
+		filePath, pos = r.read(filePath, pos, rescan)
+		r.state.position("", filePath, pos)
+
+		r.saveCheckpoint()
+
+		// Metric read time <
+		metricReadFileDur.WithLabelValues(appName).Set(time.Now().Sub(tReadDurStart).Seconds())
+	}
+}
+
+// Read file from pos and then every file created after it from the beginning,
+// new files are looked for only if dir was changed
+func (r *DirReader) read(filePath string, pos int64, rescan bool) (string, int64) {
+	for {
+		pos = r.readFile(filePath, pos)
+		if !rescan {
+			return filePath, pos
+		}
 
 		// Check new file
-		filePath, pos = r.prepare(filePath, pos)
+		next, err := r.findNextFile(filePath)
+		if err != nil {
+			r.logger.LogError(err)
+			return filePath, pos
+		}
+		if next == "" {
+			return filePath, pos
+		}
+
+		// Drain records written to old file before rotation
+		pos = r.readFile(filePath, pos)
+		if stat, err := os.Stat(filePath); err == nil && stat.Size() > pos {
+			r.logger.WarnF("Unfinished record skipped in %s at %d", filepath.Base(filePath), pos)
+		}
+		r.logger.InfoF("File %s finished, switch to %s", filepath.Base(filePath), filepath.Base(next))
+		filePath, pos = next, 0
 
 		select {
-		case <-time.After(10 * time.Second):
+		case <-r.exit:
+			return filePath, pos
+		default:
+		}
+	}
+}
 
-			// Metric read time >
-			tReadDurStart := time.Now()
+func (r *DirReader) readFile(filePath string, pos int64) int64 {
 
-			// Run FileReader
-			var fr FileReader
-			if pos > fr.pos {
-				fr.pos = pos
-			}
-			fr.dir = r
-			fr.path = filePath
-			fr.logger = r.logger
-			fr.Run()
-			pos = fr.pos
+	// File could be removed by 1C
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return pos
+	}
 
-			// Metric read time <
-			metricReadFileDur.WithLabelValues(appName).Set(time.Now().Sub(tReadDurStart).Seconds())
+	if isLGD(filePath) {
+		var lr LGDReader
+		lr.pos = pos
+		lr.dir = r
+		lr.path = filePath
+		lr.exit = r.exit
+		lr.logger = r.logger
+		if err := lr.Run(); err != nil {
+			r.logger.ErrorF("Read %s error: %v", filepath.Base(filePath), err)
+		}
+		return lr.pos
+	}
 
-		case <-r.exit:
-			return
+	var fr FileReader
+	fr.pos = pos
+	fr.dir = r
+	fr.path = filePath
+	fr.exit = r.exit
+	fr.logger = r.logger
+	if err := fr.Run(); err != nil {
+		r.logger.ErrorF("Read %s error: %v", filepath.Base(filePath), err)
+	}
+	return fr.pos
+}
+
+// Dictionary and position to start tailing from: saved checkpoint,
+// the oldest file for new dir or the end of the newest file
+func (r *DirReader) start() (filePath string, pos int64, err error) {
+	if err = r.parseMetadata(); err != nil {
+		return
+	}
+	filePath, pos = r.restore()
+	if filePath == "" && r.fromStart {
+		filePath, pos = r.first()
+	}
+	if filePath == "" {
+		filePath, pos, err = r.prepare()
+	}
+	return
+}
+
+// Position saved by previous run, empty if it is absent or does not match files
+func (r *DirReader) restore() (string, int64) {
+	cp, ok, err := r.cp.load()
+	if err != nil {
+		r.logger.LogError(err)
+		return "", 0
+	}
+	if !ok {
+		return "", 0
+	}
+	if err := cp.verify(); err != nil {
+		r.logger.WarnF("Checkpoint skipped: %v", err)
+		return "", 0
+	}
+	r.logger.InfoF("Resume from %s at %d", cp.Path, cp.Offset)
+	return cp.Path, cp.Offset
+}
+
+// Records of stopped reader could be still on the way to spool
+func (r *DirReader) drainCheckpoint(timeout time.Duration) bool {
+	ok := r.cp.drain(timeout)
+	if r.bcp != nil && r.bcp != r.cp {
+		ok = r.bcp.drain(timeout) && ok
+	}
+	return ok
+}
+
+func (r *DirReader) saveCheckpoint() {
+	if err := r.cp.save(); err != nil {
+		r.logger.LogError(err)
+	}
+	if r.bcp != nil && r.bcp != r.cp {
+		if err := r.bcp.save(); err != nil {
+			r.logger.LogError(err)
 		}
 	}
 }
 
-// If exist new file we need set new position to end new file
-func (r *DirReader) prepare(filePath string, pos int64) (string, int64) {
+// Start from the beginning of the oldest file
+func (r *DirReader) first() (string, int64) {
+	if lgd := r.lgdPath(); lgd != "" {
+		return lgd, 0
+	}
+	files, err := r.listFiles()
+	if err != nil {
+		r.logger.LogError(err)
+		return "", 0
+	}
+	if len(files) == 0 {
+		return "", 0
+	}
+	return files[0], 0
+}
 
-	// Parse metadata
-	r.parseMetadata()
+// Start from the end of the newest file
+func (r *DirReader) prepare() (string, int64, error) {
 
 	// Get last file
-	newFilePath, err := r.findNewestFile()
+	filePath, err := r.findNewestFile()
 	if err != nil {
-		r.logger.FatalError(err)
+		return "", 0, err
+	}
+	if filePath == "" {
+		return "", 0, fmt.Errorf("%s: no .lgp files", r.path)
 	}
 
 	// Find file pos
-	if newFilePath != filePath || pos <= 0 {
-		pos, err = r.findFilePos(newFilePath)
-		if err != nil {
-			r.logger.FatalError(err)
-		}
+	pos, err := r.findFilePos(filePath)
+	if err != nil {
+		return "", 0, err
 	}
 
-	return newFilePath, pos
+	return filePath, pos, nil
 }
 
 func (r *DirReader) findFilePos(filePath string) (pos int64, err error) {
+	if isLGD(filePath) {
+		return lgdLastRow(filePath)
+	}
 	stat, err := os.Stat(filePath)
 	if err != nil {
 		return
@@ -130,22 +316,58 @@ func (r *DirReader) findFilePos(filePath string) (pos int64, err error) {
 	return
 }
 
+// All .lgp files of dir in order of creation, 1C names them by date
+func (r *DirReader) listFiles() (list []string, err error) {
+	files, err := ioutil.ReadDir(r.path)
+	if err != nil {
+		return
+	}
+	for _, ff := range files {
+		if !ff.Mode().IsRegular() || strings.ToLower(path.Ext(ff.Name())) != ".lgp" {
+			continue
+		}
+		list = append(list, r.path+string(os.PathSeparator)+ff.Name())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return filepath.Base(list[i]) < filepath.Base(list[j])
+	})
+	return
+}
+
+// File created right after given one, empty if there is none. Base converted
+// to SQLite format writes only to .lgd, so it follows any .lgp and is the last one
+func (r *DirReader) findNextFile(filePath string) (string, error) {
+	if isLGD(filePath) {
+		return "", nil
+	}
+	if lgd := r.lgdPath(); lgd != "" {
+		return lgd, nil
+	}
+	files, err := r.listFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if filepath.Base(f) > filepath.Base(filePath) {
+			return f, nil
+		}
+	}
+	return "", nil
+}
+
 func (r *DirReader) findNewestFile() (newestFile string, err error) {
 
+	// New format keeps all records in one database
+	if lgd := r.lgdPath(); lgd != "" {
+		return lgd, nil
+	}
+
 	// Get one newest file
 	files, err := ioutil.ReadDir(r.path)
 	if err != nil {
-		r.logger.LogError(err)
 		return
 	}
 
-	// Hack for reset win cache
-	for _, ff := range files {
-		flags := os.O_RDONLY | os.O_SYNC
-		f, _ := os.OpenFile(r.path+string(os.PathSeparator)+ff.Name(), flags, 0644)
-		_ = f.Close()
-	}
-
 	// Get newest file by date
 	var modTime1 time.Time
 	var newestFile1 string
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Options from command line, they override app.ini
+type Options struct {
+	Backfill     bool
+	BackfillFrom string
+	BackfillTo   string
+	BackfillTail bool
+}
+
+// Bounds of period are dates as they are set, every source reads them in its time zone
+type Backfill struct {
+	Enabled  bool
+	From     string
+	To       string
+	ThenTail bool
+}
+
+var (
+	metricBackfillProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log1c_backfill_progress_ratio",
+		Help: "Какая часть истории загружена",
+	},
+		[]string{"server", "log_name"},
+	)
+	fileDateRegex = regexp.MustCompile(`^(\d{14}|\d{8})`)
+)
+
+func init() {
+	prometheus.MustRegister(metricBackfillProgress)
+}
+
+// Backfill settings from [backfill] section or command line
+func (a *App) loadBackfill() (b Backfill, err error) {
+
+	section := a.cfg.Section("backfill")
+	b.Enabled = section.Key("enabled").MustBool()
+	b.From = section.Key("from").String()
+	b.To = section.Key("to").String()
+	b.ThenTail = section.Key("then_tail").MustBool(true)
+
+	if a.Options.Backfill {
+		b.Enabled = true
+		b.From = a.Options.BackfillFrom
+		b.To = a.Options.BackfillTo
+		b.ThenTail = a.Options.BackfillTail
+	}
+
+	// Dates are checked in zone of [main], sources parse them again in own zones
+	_, _, err = b.period(a.loc)
+	return
+}
+
+// Period in time zone of source, 1C writes local time of its server
+func (b Backfill) period(loc *time.Location) (from, to time.Time, err error) {
+	if from, err = parseDate(b.From, loc, false); err != nil {
+		return
+	}
+	if to, err = parseDate(b.To, loc, true); err != nil {
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		err = fmt.Errorf("backfill from %s is not before to %s", b.From, b.To)
+	}
+	return
+}
+
+// Date only value of the end bound includes whole day
+func parseDate(s string, loc *time.Location, end bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return t, fmt.Errorf("bad date %q, want YYYY-MM-DD or YYYY-MM-DD hh:mm:ss", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Record date is inside of backfill period
+func (r *DirReader) inRange(t time.Time) bool {
+	if !r.from.IsZero() && t.Before(r.from) {
+		return false
+	}
+	if !r.to.IsZero() && !t.Before(r.to) {
+		return false
+	}
+	return true
+}
+
+// Files which contain records of period, file covers time from its name to the next file name
+func (r *DirReader) backfillFiles(from, to time.Time) (list []string, err error) {
+
+	// Records of .lgd are filtered by date while reading
+	if lgd := r.lgdPath(); lgd != "" {
+		return []string{lgd}, nil
+	}
+
+	files, err := r.listFiles()
+	if err != nil {
+		return
+	}
+
+	starts := make([]time.Time, 0, len(files))
+	named := make([]string, 0, len(files))
+	for _, f := range files {
+		name := fileDateRegex.FindString(filepath.Base(f))
+		layout := "20060102150405"
+		if len(name) == 8 {
+			layout = "20060102"
+		}
+		start, err := time.ParseInLocation(layout, name, r.loc)
+		if err != nil {
+			r.logger.WarnF("Backfill skip file %s: no date in name", filepath.Base(f))
+			continue
+		}
+		starts = append(starts, start)
+		named = append(named, f)
+	}
+
+	for i, f := range named {
+		if !to.IsZero() && !starts[i].Before(to) {
+			break
+		}
+		if !from.IsZero() && i+1 < len(named) && !starts[i+1].After(from) {
+			continue
+		}
+		list = append(list, f)
+	}
+
+	return
+}
+
+// Read history files, returns position to continue tailing from, empty one
+// if tailing should start from saved checkpoint, tail is false if reader must stop
+func (r *DirReader) loadHistory() (filePath string, pos int64, tail bool) {
+
+	b := r.app.backfill
+	appName := r.cfg.Section("main").Key("app").String()
+
+	from, to, err := b.period(r.loc)
+	if err != nil {
+		r.logger.LogError(err)
+		return
+	}
+	files, err := r.backfillFiles(from, to)
+	if err != nil {
+		r.logger.LogError(err)
+		return
+	}
+
+	// Own position for backfill, tail position stays as is
+	tailStore := r.cp
+	r.bcp = newCheckpointStore(strings.TrimSuffix(tailStore.file, ".json") + ".backfill.json")
+	r.cp = r.bcp
+	defer func() {
+		r.saveCheckpoint()
+		r.cp = tailStore
+	}()
+
+	// Continue interrupted backfill
+	resumeFile, resumePos := r.restore()
+	resume := -1
+	for i, f := range files {
+		if f == resumeFile {
+			resume = i
+		}
+	}
+	if resume < 0 {
+		resumeFile, resumePos = "", 0
+	}
+
+	// Progress in bytes
+	var total, done int64
+	sizes := make([]int64, len(files))
+	for i, f := range files {
+		stat, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		sizes[i] = stat.Size()
+		total += sizes[i]
+		if i < resume {
+			done += sizes[i]
+		}
+	}
+
+	r.logger.InfoF("Backfill start: %d files, %d bytes", len(files), total)
+
+	r.from, r.to = from, to
+	defer func() { r.from, r.to = time.Time{}, time.Time{} }()
+
+	for i, f := range files {
+		if i < resume {
+			continue
+		}
+
+		pos = 0
+		if f == resumeFile {
+			pos = resumePos
+		}
+		if err := r.parseMetadata(); err != nil {
+			r.logger.ErrorF("Backfill stopped: %v", err)
+			return "", 0, b.ThenTail
+		}
+		pos = r.readFile(f, pos)
+		filePath = f
+		r.saveCheckpoint()
+
+		done += sizes[i]
+		progress := 1.0
+		if total > 0 {
+			progress = float64(done) / float64(total)
+		}
+		metricBackfillProgress.WithLabelValues(appName, r.name).Set(progress)
+		r.logger.InfoF("Backfill %.1f%%: file %d of %d %s done", progress*100, i+1, len(files), filepath.Base(f))
+
+		select {
+		case <-r.exit:
+			return "", 0, false
+		default:
+		}
+	}
+
+	r.logger.Info("Backfill finished")
+
+	if !b.ThenTail {
+		return "", 0, false
+	}
+
+	// Without end of period continue right after history
+	if to.IsZero() && filePath != "" {
+		return filePath, pos, true
+	}
+
+	return "", 0, true
+}
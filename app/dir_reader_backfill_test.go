@@ -0,0 +1,41 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// Same dates are different moments for sources in different zones
+func TestBackfillPeriodInSourceZone(t *testing.T) {
+
+	b := Backfill{Enabled: true, From: "2020-08-12", To: "2020-08-12 18:00:00"}
+	msk := time.FixedZone("MSK", 3*3600)
+	vlat := time.FixedZone("VLAT", 10*3600)
+
+	from, to, err := b.period(msk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(time.Date(2020, 8, 11, 21, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2020, 8, 12, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("period in MSK %s - %s", from.UTC(), to.UTC())
+	}
+
+	from, _, err = b.period(vlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(time.Date(2020, 8, 11, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("period in VLAT starts at %s", from.UTC())
+	}
+
+	// Date only end includes whole day
+	b.To = "2020-08-12"
+	if _, to, _ = b.period(msk); !to.Equal(time.Date(2020, 8, 13, 0, 0, 0, 0, msk)) {
+		t.Errorf("end of day is %s", to)
+	}
+
+	b.To = "2020-08-11"
+	if _, _, err = b.period(msk); err == nil {
+		t.Error("period with end before start is accepted")
+	}
+}
@@ -0,0 +1,406 @@
+package app
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/moskvorechie/log1c/bracket"
+	"github.com/moskvorechie/log1c/lgp"
+	"github.com/moskvorechie/logs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// New format of event log, SQLite database with table EventLog
+const lgdName = "1Cv8.lgd"
+
+// Seconds from 0001-01-01 to 1970-01-01, 1C dates are counted in 1/10000 of second from 0001-01-01
+const lgdEpoch = 62135596800
+
+// Rows read by one query
+const lgdBatch = 1000
+
+// Reads rows of EventLog after given rowID
+type LGDReader struct {
+	dir    *DirReader
+	logger logs.Log
+	exit   chan bool
+	path   string
+	hash   string
+	pos    int64
+}
+
+// Columns of EventLog row
+type lgdRecord struct {
+	RowID             int64
+	Severity          int64
+	Date              int64
+	ConnectID         int64
+	Session           int64
+	TransactionStatus int64
+	TransactionDate   int64
+	TransactionID     int64
+	UserCode          int64
+	ComputerCode      int64
+	AppCode           int64
+	EventCode         int64
+	Comment           string
+	MetadataCodes     string
+	Data              string
+	DataType          int64 `json:"-"`
+	DataPresentation  string
+	WorkServerCode    int64
+	PrimaryPortCode   int64
+	SecondaryPortCode int64
+}
+
+// Type of data is not in raw row, hashes of rows in checkpoints are kept
+const lgdColumns = `rowID, severity, date, connectID, session, transactionStatus, transactionDate, transactionID,
+	userCode, computerCode, appCode, eventCode, comment, metadataCodes, data, dataPresentation,
+	workServerCode, primaryPortCode, secondaryPortCode, dataType`
+
+// Path of .lgd database of dir, empty if dir uses .lgp files
+func (r *DirReader) lgdPath() string {
+	p := r.path + string(os.PathSeparator) + lgdName
+	if stat, err := os.Stat(p); err == nil && stat.Mode().IsRegular() {
+		return p
+	}
+	return ""
+}
+
+func isLGD(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".lgd")
+}
+
+// Database is opened read only, 1C keeps writing to it
+func openLGD(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA busy_timeout = 10000"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Run reads rows after pos, error is returned if database can not be read
+func (f *LGDReader) Run() error {
+
+	defer func() {
+		if rc := recover(); rc != nil {
+			f.logger.Error("Fatal stack: \n" + string(debug.Stack()))
+			f.logger.FatalF("Recovered Fatal %v", rc)
+		}
+	}()
+
+	// Set app to logger
+	f.logger.SetCustomLogger(f.logger.Logger().With().Str("file_name", filepath.Base(f.path)).Logger())
+
+	// Start & stop log
+	f.logger.Debug("LGDReader start")
+	defer f.logger.Debug("LGDReader stop")
+
+	// File hash
+	h := sha256.New()
+	h.Write([]byte(f.path))
+	f.hash = fmt.Sprintf("%x", h.Sum(nil))
+
+	db, err := openLGD(f.path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if f.dir.cfg.Section("main").Key("test").MustBool() {
+		f.pos = 0
+	}
+
+	// Read rows by batches, database is locked while query is open
+	for {
+		list, err := lgdRecords(db, f.pos, lgdBatch)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return nil
+		}
+
+		for _, rec := range list {
+			select {
+			case <-f.exit:
+				return nil
+			default:
+			}
+
+			f.pos = rec.RowID
+			f.dir.state.position("", f.path, f.pos)
+
+			data, _ := json.Marshal(rec)
+			raw := string(data)
+			f.logger.Debug(raw)
+
+			cp := Checkpoint{
+				Path:       f.path,
+				Offset:     rec.RowID,
+				RecordHash: recordHash(data),
+				RecordLen:  int64(len(data)),
+			}
+
+			m, err := f.prepareMessage(rec, raw)
+			if err != nil {
+				f.logger.ErrorF("Parse record error: %v: %v", err, raw)
+				f.dir.cp.skip(cp)
+				continue
+			}
+
+			f.logger.DebugF("Send row: %v", m)
+
+			if !m.Allow || !f.dir.inRange(m.ДатаВремя) {
+				f.dir.cp.skip(cp)
+				continue
+			}
+			m.store = f.dir.cp
+			m.mark = f.dir.cp.track(cp)
+
+			select {
+			case <-f.exit:
+				return nil
+			case f.dir.app.mess <- m:
+			}
+		}
+	}
+}
+
+func lgdRecords(db *sql.DB, after int64, limit int) (list []lgdRecord, err error) {
+	rows, err := db.Query("SELECT "+lgdColumns+" FROM EventLog WHERE rowID > ? ORDER BY rowID LIMIT ?", after, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rec lgdRecord
+		var comment, meta, data, presentation sql.NullString
+		var dataType sql.NullInt64
+		err = rows.Scan(&rec.RowID, &rec.Severity, &rec.Date, &rec.ConnectID, &rec.Session,
+			&rec.TransactionStatus, &rec.TransactionDate, &rec.TransactionID,
+			&rec.UserCode, &rec.ComputerCode, &rec.AppCode, &rec.EventCode,
+			&comment, &meta, &data, &presentation,
+			&rec.WorkServerCode, &rec.PrimaryPortCode, &rec.SecondaryPortCode, &dataType)
+		if err != nil {
+			return
+		}
+		rec.Comment, rec.MetadataCodes, rec.Data, rec.DataPresentation = comment.String, meta.String, data.String, presentation.String
+		rec.DataType = dataType.Int64
+		list = append(list, rec)
+	}
+	err = rows.Err()
+	return
+}
+
+// Row in the same form as .lgp record
+func (f *LGDReader) prepareMessage(rec lgdRecord, raw string) (m Message, err error) {
+
+	row := &lgp.Record{}
+	row.Date = lgdTime(rec.Date).Format("20060102150405")
+
+	switch rec.Severity {
+	case 1:
+		row.Level = "I"
+	case 2:
+		row.Level = "W"
+	case 3:
+		row.Level = "E"
+	case 4:
+		row.Level = "N"
+	default:
+		err = fmt.Errorf("unknown severity %d", rec.Severity)
+		return
+	}
+
+	switch rec.TransactionStatus {
+	case 0:
+		row.TransactionStatus = "U"
+	case 1:
+		row.TransactionStatus = "R"
+	case 2:
+		row.TransactionStatus = "N"
+	case 3:
+		row.TransactionStatus = "C"
+	}
+	row.Transaction = strconv.FormatInt(rec.TransactionDate, 16) + "-" + strconv.FormatInt(rec.TransactionID, 16)
+
+	row.User = rec.UserCode
+	row.Computer = rec.ComputerCode
+	row.App = rec.AppCode
+	row.Connection = rec.ConnectID
+	row.Event = rec.EventCode
+	row.Comment = rec.Comment
+	row.Presentation = rec.DataPresentation
+	row.Server = rec.WorkServerCode
+	row.Port1 = rec.PrimaryPortCode
+	row.Port2 = rec.SecondaryPortCode
+	row.Session = rec.Session
+
+	// Codes are separated by comma when event has several metadata objects,
+	// message has one metadata field as for .lgp, so only the first one is kept
+	if codes := strings.Split(rec.MetadataCodes, ","); codes[0] != "" {
+		if row.Metadata, err = strconv.ParseInt(strings.TrimSpace(codes[0]), 10, 64); err != nil {
+			return
+		}
+		if len(codes) > 1 {
+			f.logger.DebugF("Row %d has %d metadata objects, the first one is kept", rec.RowID, len(codes))
+		}
+	}
+
+	// Data of type 0 is absent, values in bracket form are decoded as in .lgp,
+	// others are kept as is with number of their type
+	plain := false
+	switch {
+	case rec.DataType == 0 && rec.Data == "":
+	case strings.HasPrefix(rec.Data, "{"):
+		n, perr := bracket.Parse(rec.Data)
+		if perr != nil {
+			f.logger.WarnF("Data of row %d is kept as is: %v", rec.RowID, perr)
+			plain = true
+			break
+		}
+		row.Data = n
+	default:
+		plain = true
+	}
+
+	m, err = f.dir.prepareMessage(row, raw)
+	if err != nil {
+		return
+	}
+	if plain {
+		m.Данные = strconv.FormatInt(rec.DataType, 10)
+		m.ДанныеЗначение = &lgp.Value{Type: m.Данные, String: rec.Data}
+	}
+
+	// ID
+	h := sha256.New()
+	h.Write([]byte(f.hash + strconv.FormatInt(rec.RowID, 10)))
+	m.ID = fmt.Sprintf("%x", h.Sum(nil))
+
+	return
+}
+
+// Local time of server written as 1C date
+func lgdTime(v int64) time.Time {
+	return time.Unix(v/10000-lgdEpoch, 0).UTC()
+}
+
+// Last rowID, tail starts after it
+func lgdLastRow(path string) (rowID int64, err error) {
+	db, err := openLGD(path)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	var v sql.NullInt64
+	err = db.QueryRow("SELECT MAX(rowID) FROM EventLog").Scan(&v)
+	return v.Int64, err
+}
+
+// Check that row of checkpoint still has the same content
+func verifyLGD(cp Checkpoint) error {
+	db, err := openLGD(cp.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if cp.Offset == 0 {
+		return nil
+	}
+	list, err := lgdRecords(db, cp.Offset-1, 1)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 || list[0].RowID != cp.Offset {
+		return fmt.Errorf("database %s has no row %d", cp.Path, cp.Offset)
+	}
+	data, _ := json.Marshal(list[0])
+	if recordHash(data) != cp.RecordHash {
+		return fmt.Errorf("database %s has another row %d", cp.Path, cp.Offset)
+	}
+	return nil
+}
+
+// Dictionaries of .lgd are kept in tables *Codes, 1C adds rows with growing
+// codes, so only codes after the last read ones are queried. Whole dictionary
+// is read again if database was replaced.
+func (r *DirReader) parseLGDMetadata(path string) error {
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	db, err := openLGD(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r.metaMu.Lock()
+	defer r.metaMu.Unlock()
+	if r.meta == nil || r.metaFile == nil || !os.SameFile(stat, r.metaFile) {
+		r.meta = lgp.NewDictionary()
+		r.metaCodes = make(map[lgp.ItemType]int64)
+		r.metaFile = stat
+	}
+	count := r.meta.Len()
+
+	// Users and metadata have uuid, ports have number instead of name
+	tables := []struct {
+		name   string
+		typ    lgp.ItemType
+		uuid   bool
+		column string
+	}{
+		{"UserCodes", lgp.TypeUser, true, "name"},
+		{"ComputerCodes", lgp.TypeComputer, false, "name"},
+		{"AppCodes", lgp.TypeApp, false, "name"},
+		{"EventCodes", lgp.TypeEvent, false, "name"},
+		{"MetadataCodes", lgp.TypeMetadata, true, "name"},
+		{"WorkServerCodes", lgp.TypeServer, false, "name"},
+		{"PrimaryPortCodes", lgp.TypePort1, false, "port"},
+		{"SecondaryPortCodes", lgp.TypePort2, false, "port"},
+	}
+	for _, t := range tables {
+		uuidColumn := "''"
+		if t.uuid {
+			uuidColumn = "uuid"
+		}
+		rows, err := db.Query("SELECT code, "+uuidColumn+", "+t.column+" FROM "+t.name+" WHERE code > ? ORDER BY code", r.metaCodes[t.typ])
+		if err != nil {
+			r.logger.ErrorF("%s read err: %v", t.name, err)
+			continue
+		}
+		for rows.Next() {
+			var item lgp.Item
+			var uuid, name sql.NullString
+			if err := rows.Scan(&item.Code, &uuid, &name); err != nil {
+				r.logger.ErrorF("%s read err: %v", t.name, err)
+				break
+			}
+			item.UUID, item.Name = uuid.String, name.String
+			r.meta.Set(t.typ, item)
+			r.metaCodes[t.typ] = item.Code
+		}
+		rows.Close()
+	}
+
+	r.logger.DebugF("Metadata %d records read from %s", r.meta.Len()-count, filepath.Base(path))
+	return nil
+}
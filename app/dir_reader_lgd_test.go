@@ -0,0 +1,193 @@
+package app
+
+import (
+	"database/sql"
+	"github.com/moskvorechie/log1c/lgp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tables of 1Cv8.lgd which are read by service
+const lgdSchema = `
+CREATE TABLE AppCodes (code INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE ComputerCodes (code INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE EventCodes (code INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE MetadataCodes (code INTEGER PRIMARY KEY, uuid TEXT, name TEXT);
+CREATE TABLE PrimaryPortCodes (code INTEGER PRIMARY KEY, port INTEGER);
+CREATE TABLE SecondaryPortCodes (code INTEGER PRIMARY KEY, port INTEGER);
+CREATE TABLE UserCodes (code INTEGER PRIMARY KEY, uuid TEXT, name TEXT);
+CREATE TABLE WorkServerCodes (code INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE EventLog (rowID INTEGER PRIMARY KEY, severity INTEGER, date INTEGER, connectID INTEGER,
+	session INTEGER, transactionStatus INTEGER, transactionDate INTEGER, transactionID INTEGER,
+	userCode INTEGER, computerCode INTEGER, appCode INTEGER, eventCode INTEGER, comment TEXT,
+	metadataCodes TEXT, sessionDataSplitCode INTEGER, dataType INTEGER, data TEXT, dataPresentation TEXT,
+	workServerCode INTEGER, primaryPortCode INTEGER, secondaryPortCode INTEGER);
+INSERT INTO AppCodes VALUES (1, '1CV8C');
+INSERT INTO ComputerCodes VALUES (1, 'PC1');
+INSERT INTO EventCodes VALUES (1, '_$Data$_.Update');
+INSERT INTO MetadataCodes VALUES (1, 'fa5b8a6a-d6f6-11e9-9d7c-00155d000402', 'Справочник.Номенклатура');
+INSERT INTO MetadataCodes VALUES (2, '0c5b8a6a-d6f6-11e9-9d7c-00155d000402', 'Документ.Заказ');
+INSERT INTO PrimaryPortCodes VALUES (1, 1541);
+INSERT INTO SecondaryPortCodes VALUES (1, 1560);
+INSERT INTO UserCodes VALUES (1, '9d7c0015-5d00-0402-11e9-d6f6fa5b8a6a', 'Admin');
+INSERT INTO WorkServerCodes VALUES (1, 'SRV');
+`
+
+// Date of event as 1C writes it to .lgd
+func lgdDate(t time.Time) int64 {
+	return (t.Unix() + lgdEpoch) * 10000
+}
+
+func createLGD(t *testing.T, path string, rows [][]interface{}) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(lgdSchema); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		_, err := db.Exec(`INSERT INTO EventLog (rowID, severity, date, connectID, session, transactionStatus,
+			transactionDate, transactionID, userCode, computerCode, appCode, eventCode, comment, metadataCodes,
+			sessionDataSplitCode, dataType, data, dataPresentation, workServerCode, primaryPortCode, secondaryPortCode)
+			VALUES (?, ?, ?, 1, 2, 2, 0, 0, 1, 1, 1, 1, ?, ?, 0, ?, ?, '', 1, 1, 1)`, row...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func execLGD(t *testing.T, path, query string) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestLGDReader(t *testing.T, dir string) (*App, *DirReader) {
+	a := newConsoleApp()
+	src := &Source{Name: "lgd", Path: dir, Format: formatEventLog, Loc: time.UTC, MinLevel: "debug"}
+	a.setSource(src)
+	r := a.newDirReader(src, a.exit)
+	r.cp = newCheckpointStore("")
+	r.state = a.sourceState(src.Name)
+	return a, r
+}
+
+func TestLGDReaderMessages(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "log1c-lgd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lgdName)
+
+	at := time.Date(2020, 8, 12, 12, 1, 5, 0, time.UTC)
+	createLGD(t, path, [][]interface{}{
+		{1, 1, lgdDate(at), "", "1,2", 0, ""},
+		{2, 3, lgdDate(at.Add(time.Second)), "ошибка", "1", 2, `{"S","текст"}`},
+		{3, 2, lgdDate(at.Add(2 * time.Second)), "", "", 5, "plain"},
+		{4, 9, lgdDate(at.Add(3 * time.Second)), "", "", 0, ""},
+	})
+
+	a, r := newTestLGDReader(t, dir)
+	if err := r.parseMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if pos := r.readFile(path, 0); pos != 4 {
+		t.Errorf("position %d, want last row 4", pos)
+	}
+
+	// Row of unknown severity is skipped
+	if len(a.mess) != 3 {
+		t.Fatalf("got %d messages, want 3", len(a.mess))
+	}
+	list := []Message{<-a.mess, <-a.mess, <-a.mess}
+
+	m := list[0]
+	if !m.ДатаВремя.Equal(at) || m.Level != "info" || m.Пользователь != "Admin" || m.Компьютер != "PC1" ||
+		m.Приложение != "1CV8C" || m.Событие != "_$Data$_.Update" || m.Сервер != "SRV" {
+		t.Errorf("unexpected first message %+v", m)
+	}
+	if m.Метаданные != "Справочник.Номенклатура" || m.МетаданныеUUID != "fa5b8a6a-d6f6-11e9-9d7c-00155d000402" {
+		t.Errorf("metadata %q %q, want the first object", m.Метаданные, m.МетаданныеUUID)
+	}
+	if m.Порт1 != "1541" || m.Порт2 != "1560" {
+		t.Errorf("ports %q %q, want numbers", m.Порт1, m.Порт2)
+	}
+	if m.Данные != "" || m.ДанныеЗначение != nil {
+		t.Errorf("data %q %+v, want none", m.Данные, m.ДанныеЗначение)
+	}
+
+	m = list[1]
+	if m.Level != "error" || m.Комментарий != "ошибка" {
+		t.Errorf("unexpected second message %+v", m)
+	}
+	if m.Данные != "S" || m.ДанныеЗначение == nil || m.ДанныеЗначение.String != "текст" {
+		t.Errorf("data %q %+v, want decoded string", m.Данные, m.ДанныеЗначение)
+	}
+
+	// Data not in bracket form is kept as is
+	m = list[2]
+	if m.Level != "warning" || m.Данные != "5" || m.ДанныеЗначение == nil || m.ДанныеЗначение.String != "plain" {
+		t.Errorf("data %q %+v, want raw value of type 5", m.Данные, m.ДанныеЗначение)
+	}
+	if m.ID == list[1].ID {
+		t.Error("messages of different rows have the same ID")
+	}
+}
+
+func TestLGDMetadataIncremental(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "log1c-lgd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lgdName)
+	createLGD(t, path, nil)
+
+	_, r := newTestLGDReader(t, dir)
+	if err := r.parseMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if r.meta.Len() != 9 {
+		t.Fatalf("got %d items, want 9", r.meta.Len())
+	}
+	meta := r.meta
+
+	// Codes added by 1C are read into the same dictionary
+	execLGD(t, path, "INSERT INTO UserCodes VALUES (2, '', 'User2')")
+	if err := r.parseMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if r.meta != meta || r.meta.Name(lgp.TypeUser, 2) != "User2" || r.meta.Len() != 10 {
+		t.Errorf("new user is not added, %d items", r.meta.Len())
+	}
+	if r.metaCodes[lgp.TypeUser] != 2 || r.metaCodes[lgp.TypePort1] != 1 {
+		t.Errorf("last codes %v", r.metaCodes)
+	}
+
+	// Replaced database is read again
+	next := filepath.Join(dir, "next.lgd")
+	createLGD(t, next, nil)
+	execLGD(t, next, "UPDATE UserCodes SET name = 'Admin2' WHERE code = 1")
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.parseMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if r.meta.Name(lgp.TypeUser, 1) != "Admin2" || r.meta.Len() != 9 {
+		t.Errorf("dictionary is not reloaded: user %q, %d items", r.meta.Name(lgp.TypeUser, 1), r.meta.Len())
+	}
+}
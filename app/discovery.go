@@ -0,0 +1,276 @@
+package app
+
+import (
+	"github.com/moskvorechie/log1c/bracket"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cluster registry, list of infobases is its third item
+const clusterRegistry = "1CV8Clst.lst"
+
+// Infobase registered in cluster, its event log is in reg_<port>/<uuid>/1Cv8Log
+type Infobase struct {
+	UUID     string
+	Name     string
+	Descr    string
+	Registry string
+	LogPath  string
+}
+
+var (
+	metricDiscoveryBases = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log1c_discovery_infobases",
+		Help: "Сколько баз найдено в реестре кластера",
+	},
+		[]string{"server"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricDiscoveryBases)
+}
+
+// Infobases of all clusters in srvinfo dir which already have event log
+func discoverInfobases(srvinfo string) (list []Infobase, err error) {
+
+	dirs, err := ioutil.ReadDir(srvinfo)
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !strings.HasPrefix(strings.ToLower(dir.Name()), "reg_") {
+			continue
+		}
+		reg := filepath.Join(srvinfo, dir.Name())
+		bases, err := readClusterRegistry(filepath.Join(reg, clusterRegistry))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bases {
+			b.Registry = reg
+			b.LogPath = filepath.Join(reg, b.UUID, "1Cv8Log")
+			if !hasEventLog(b.LogPath) {
+				continue
+			}
+			list = append(list, b)
+		}
+	}
+
+	// Names of the same base in several clusters get suffix in stable order
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Registry < list[j].Registry
+	})
+	return
+}
+
+// Records of infobases are {uuid,"name","descr",...} after count
+func readClusterRegistry(path string) (list []Infobase, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	dec := bracket.NewDecoder(file)
+	dec.KeepRaw(false)
+	root, err := dec.Decode()
+	if err != nil {
+		return
+	}
+	for _, item := range root.At(2).Items {
+		if !item.IsList() || item.At(0).Kind != bracket.GUID {
+			continue
+		}
+		list = append(list, Infobase{
+			UUID:  item.At(0).Str(),
+			Name:  item.At(1).Str(),
+			Descr: item.At(2).Str(),
+		})
+	}
+	return
+}
+
+// Reader needs dictionary and at least one log file
+func hasEventLog(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, lgdName)); err == nil {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1Cv8.lgf")); err != nil {
+		return false
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.lgp"))
+	return len(files) > 0
+}
+
+// Discovery runs until it is disabled by reload or app exits, must be called under reloadMu
+func (a *App) startDiscovery() {
+	if a.discovering {
+		return
+	}
+	a.discovering = true
+	go a.runDiscovery()
+}
+
+// Start readers for new infobases and stop them for removed ones every
+// interval, bases set in config are skipped
+func (a *App) runDiscovery() {
+
+	defer func() {
+		if rc := recover(); rc != nil {
+			a.logger.Error("Fatal stack: \n" + string(debug.Stack()))
+			a.logger.FatalF("Recovered Fatal %v", rc)
+		}
+	}()
+
+	a.logger.Info("Discovery start")
+	defer a.logger.Info("Discovery stop")
+
+	// Bases found on start are read like configured ones
+	started := false
+	for {
+		interval, ok := a.discover(started)
+		if !ok {
+			return
+		}
+		started = true
+
+		select {
+		case <-a.exit:
+			return
+		case <-a.discoveryWake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// One look into registry, ok is false if discovery is stopped
+func (a *App) discover(started bool) (interval time.Duration, ok bool) {
+
+	// Sources are not changed by reload meanwhile
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	select {
+	case <-a.exit:
+		return 0, false
+	default:
+	}
+
+	cfg := a.config()
+	section := cfg.Section("discovery")
+	interval = section.Key("interval").MustDuration(time.Minute)
+
+	// Configured sources win over discovered ones
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	running := make(map[string]bool)
+	a.sourcesMu.RLock()
+	for _, src := range a.sources {
+		if src.discovered {
+			running[src.Name] = true
+			continue
+		}
+		names[src.Name] = true
+		paths[strings.ToLower(filepath.Clean(src.Path))] = true
+	}
+	a.sourcesMu.RUnlock()
+
+	// Disabled by reload
+	if !section.Key("enabled").MustBool() {
+		for name := range running {
+			a.logger.InfoF("Discovery is disabled, stop reading base %s", name)
+			a.stopSource(name)
+			a.deleteSource(name)
+		}
+		metricDiscoveryBases.WithLabelValues(a.name).Set(0)
+		a.discovering = false
+		return 0, false
+	}
+
+	srvinfo := section.Key("path").MustString(`C:\Program Files\1cv8\srvinfo`)
+	exclude := section.Key("exclude").Strings(",")
+	level := cfg.Section("main").Key("msg_level").MustString("debug")
+	bases, err := discoverInfobases(srvinfo)
+	if err != nil {
+		a.logger.ErrorF("Discovery error: %v", err)
+		return interval, true
+	}
+
+	found := make(map[string]bool)
+	for _, b := range bases {
+		if paths[strings.ToLower(filepath.Clean(b.LogPath))] || matchAny(b.Name, exclude) {
+			continue
+		}
+		name := b.Name
+		if names[name] {
+			a.logger.WarnF("Discovered base %s is skipped, source with the same name is set", name)
+			continue
+		}
+		if found[name] {
+			name += "_" + strings.TrimPrefix(strings.ToLower(filepath.Base(b.Registry)), "reg_")
+		}
+		found[name] = true
+
+		// Level of [main] could be changed by reload
+		if running[name] {
+			if src := a.source(name); src != nil && src.MinLevel != level {
+				updated := *src
+				updated.MinLevel = level
+				a.setSource(&updated)
+			}
+			continue
+		}
+
+		src := &Source{
+			Name:       name,
+			Path:       b.LogPath,
+			Format:     formatEventLog,
+			MinLevel:   level,
+			discovered: true,
+		}
+		if src.Loc, err = a.sourceLocation(cfg, name); err != nil {
+			a.logger.ErrorF("Discovered base %s error: %v", name, err)
+			continue
+		}
+		a.logger.InfoF("Base %s (%s) is found, read %s", name, b.UUID, b.LogPath)
+		a.setSource(src)
+		mode := startTail
+		if started {
+			mode = startFirst
+		}
+		a.startSource(src, mode)
+	}
+
+	// Bases removed from registry
+	for name := range running {
+		if found[name] {
+			continue
+		}
+		a.logger.InfoF("Base %s is removed from registry, stop reading it", name)
+		a.stopSource(name)
+		a.deleteSource(name)
+	}
+	metricDiscoveryBases.WithLabelValues(a.name).Set(float64(len(found)))
+
+	return interval, true
+}
+
+// Name matches one of glob patterns, case is ignored
+func matchAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -1,16 +1,15 @@
 package app
 
 import (
-	"bufio"
 	"crypto/sha256"
 	"fmt"
+	"github.com/moskvorechie/log1c/lgp"
 	"github.com/moskvorechie/logs"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -23,7 +22,9 @@ type FileReader struct {
 	pos    int64
 }
 
-func (f *FileReader) Run() {
+// Run reads records from pos to the end of file, error is returned if file
+// can not be read, reader is not stopped by it
+func (f *FileReader) Run() error {
 
 	defer func() {
 		if rc := recover(); rc != nil {
@@ -42,100 +43,85 @@ func (f *FileReader) Run() {
 	// File hash
 	f.calcFileHash()
 
-	// Start read file from end
+	// Start read file from pos
 	file, err := os.Open(f.path)
 	if err != nil {
-		f.logger.FatalError(err)
+		return err
 	}
 	defer file.Close()
-	reader := bufio.NewReader(file)
-	if f.pos <= 0 {
-		stat, err := os.Stat(f.path)
-		if err != nil {
-			f.logger.FatalError(err)
-		}
-		f.pos = stat.Size()
-	}
 	if f.dir.cfg.Section("main").Key("test").MustBool() {
 		f.pos = 0
 	}
-	_, err = reader.Discard(int(f.pos))
+	_, err = file.Seek(f.pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// File identity for checkpoints
+	identity, identitySize, err := fileIdentity(file, identityLen)
 	if err != nil {
-		f.logger.FatalError(err)
+		return err
 	}
 
-	// Read file
-	var r Row
-	var res []string
+	// Read records, unfinished record at the end will be read next time
+	rd := lgp.NewReader(file)
+	rd.SetOffset(f.pos)
 	for {
 		select {
 		case <-f.exit:
-			return
+			return nil
 		default:
 
-			// Read row
-			row, err := reader.ReadString('\n')
-			exit := err == io.EOF
-			if err != nil && err != io.EOF {
-				f.logger.FatalError(err)
+			row, err := rd.Read()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
 			}
-			if len(row) < 0 || row == "" {
-				if exit {
-					return
-				} else {
-					continue
-				}
+			if _, ok := err.(*lgp.RecordError); !ok && err != nil {
+				return err
 			}
 
-			f.logger.Debug(row)
-
-			// Save pos
-			f.pos += int64(len(row))
+			// Save pos, it is shown by /status while file is read
+			f.pos = rd.Offset()
+			f.dir.state.position("", f.path, f.pos)
+
+			raw := string(rd.Raw())
+			f.logger.Debug(raw)
+
+			// Position after record for checkpoint
+			cp := Checkpoint{
+				Path:        f.path,
+				Offset:      f.pos,
+				Identity:    identity,
+				IdentityLen: identitySize,
+				RecordHash:  recordHash(rd.Raw()),
+				RecordLen:   int64(len(rd.Raw())),
+			}
 
-			// Parse row
-			s := row[:1]
-			if s == "{" && r.startedMain == false {
-				r.startedMain = true
-				r.dataOpenBrackets = 0
+			if err != nil {
+				f.logger.ErrorF("Parse record error: %v: %v", err, raw)
+				f.dir.cp.skip(cp)
+				continue
 			}
-			if r.startedMain == false {
-				if exit {
-					return
-				} else {
-					continue
-				}
+			m, err := f.prepareMessage(row, raw)
+			if err != nil {
+				f.logger.ErrorF("Parse record error: %v: %v", err, raw)
+				f.dir.cp.skip(cp)
+				continue
 			}
-			r.DataRow += row
-			row = strings.TrimSpace(row)
-			r.dataOpenBrackets += strings.Count(row, "{")
-			r.dataOpenBrackets -= strings.Count(row, "}")
-			if r.startedMain == true && r.dataOpenBrackets == 0 {
-				res = f.dir.app.regex1.FindStringSubmatch(r.DataRow)
-				if len(res) < 21 {
-					f.logger.ErrorF("Regex find not work: %v", r.DataRow)
-					r = Row{}
-					if exit {
-						return
-					} else {
-						continue
-					}
-				}
-				r = Row{}
-				m, err := f.prepareMessage(res)
-				if err != nil {
-					f.logger.FatalError(err)
-				}
-
-				f.logger.DebugF("Send row: %v", m)
-
-				select {
-				case <-f.exit:
-					return
-				default:
-					if m.Allow {
-						f.dir.app.mess <- m
-					}
-				}
+
+			f.logger.DebugF("Send row: %v", m)
+
+			if !m.Allow || !f.dir.inRange(m.ДатаВремя) {
+				f.dir.cp.skip(cp)
+				continue
+			}
+			m.store = f.dir.cp
+			m.mark = f.dir.cp.track(cp)
+
+			select {
+			case <-f.exit:
+				return nil
+			case f.dir.app.mess <- m:
 			}
 		}
 	}
@@ -147,111 +133,119 @@ func (f *FileReader) calcFileHash() {
 	f.hash = fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func (f *FileReader) prepareMessage(res []string) (m Message, err error) {
+func (f *FileReader) prepareMessage(r *lgp.Record, raw string) (m Message, err error) {
+
+	m, err = f.dir.prepareMessage(r, raw)
+	if err != nil {
+		return
+	}
 
 	// ID
 	h := sha256.New()
 	h.Write([]byte(f.hash + strconv.Itoa(int(f.pos))))
 	m.ID = fmt.Sprintf("%x", h.Sum(nil))
-	m.ДатаВремя, err = time.ParseInLocation("20060102150405", res[1], f.dir.app.loc)
+
+	return
+}
+
+// Message from record of any log format
+func (r *DirReader) prepareMessage(row *lgp.Record, raw string) (m Message, err error) {
+
+	// Dictionary could be written after record
+	r.refreshMetadata(row)
+	r.metaMu.RLock()
+	m, err = recordMessage(row, r.meta, r.loc, raw)
+	r.metaMu.RUnlock()
 	if err != nil {
-		f.logger.LogError(err)
 		return
 	}
 
-	m.СыраяСтрока = res[0]
-
-	m.СтатусТранзакции = res[2]
-	m.СтатусТранзакцииИд = res[2]
-	switch res[2] {
-	case "N":
-		m.СтатусТранзакции = "Отсутствует"
-	case "U":
-		m.СтатусТранзакции = "Зафиксирована"
-	case "R":
-		m.СтатусТранзакции = "Не завершена"
-	case "C":
-		m.СтатусТранзакции = "Отменена"
-	}
+	m.NameDB = r.name
+	m.App = r.app.name
+	m.Folder = r.path
 
-	m.НомерТранзакции = res[3] + "-" + res[4]
+	m.Source = r.name
+	m.Allow = r.app.source(r.name).allow(&m)
 
-	m.Пользователь = res[5]
-	if id, err := strconv.ParseInt(res[5], 10, 64); err == nil {
-		m.ПользовательИд = id
-		m.Пользователь = f.dir.meta.Users[id].Name
-	}
+	return
+}
 
-	m.Компьютер = res[6]
-	if id, err := strconv.ParseInt(res[6], 10, 64); err == nil {
-		m.КомпьютерИд = id
-		m.Компьютер = f.dir.meta.Computers[id].Name
-	}
+// Fields of record with names from dictionary
+func recordMessage(row *lgp.Record, meta *lgp.Dictionary, loc *time.Location, raw string) (m Message, err error) {
 
-	m.Приложение = res[7]
-	if id, err := strconv.ParseInt(res[7], 10, 64); err == nil {
-		m.ПриложениеИд = id
-		m.Приложение = f.dir.meta.Apps[id].Name
+	m.ДатаВремя, err = row.Time(loc)
+	if err != nil {
+		return
 	}
+	m.ДатаВремяUTC = m.ДатаВремя.UTC()
 
-	m.Событие = res[9]
-	if id, err := strconv.ParseInt(res[9], 10, 64); err == nil {
-		m.СобытиеИд = id
-		m.Событие = f.dir.meta.Events[id].Name
-	}
+	m.Log = "eventlog"
+	m.СыраяСтрока = raw
 
-	m.Метаданные = res[12]
-	if id, err := strconv.ParseInt(res[12], 10, 64); err == nil {
-		m.МетаданныеИд = id
-		m.Метаданные = f.dir.meta.Subs[id].Name
-	}
+	m.СтатусТранзакции = lgp.TransactionStatusName(row.TransactionStatus)
+	m.СтатусТранзакцииИд = row.TransactionStatus
+
+	m.НомерТранзакции = row.Transaction
+
+	user, _ := meta.Get(lgp.TypeUser, row.User)
+	m.ПользовательИд = row.User
+	m.Пользователь = user.Name
+	m.ПользовательUUID = user.UUID
+
+	m.КомпьютерИд = row.Computer
+	m.Компьютер = meta.Name(lgp.TypeComputer, row.Computer)
 
-	m.Сервер = res[15]
-	if id, err := strconv.ParseInt(res[15], 10, 64); err == nil {
-		m.СерверИд = id
-		m.Сервер = f.dir.meta.Servers[id].Name
+	m.ПриложениеИд = row.App
+	m.Приложение = meta.Name(lgp.TypeApp, row.App)
+
+	m.СобытиеИд = row.Event
+	m.Событие = meta.Name(lgp.TypeEvent, row.Event)
+
+	sub, _ := meta.Get(lgp.TypeMetadata, row.Metadata)
+	m.МетаданныеИд = row.Metadata
+	m.Метаданные = sub.Name
+	m.МетаданныеUUID = sub.UUID
+
+	m.СерверИд = row.Server
+	m.Сервер = meta.Name(lgp.TypeServer, row.Server)
+
+	m.Соединение = strconv.FormatInt(row.Connection, 10)
+	m.Комментарий = row.Comment
+	m.Данные = row.Data.At(0).Str()
+	m.ДанныеЗначение = lgp.ParseValue(row.Data, loc)
+	m.Представление = row.Presentation
+	m.Порт1 = strconv.FormatInt(row.Port1, 10)
+	if p, ok := meta.Get(lgp.TypePort1, row.Port1); ok {
+		m.Порт1 = p.Name
+	}
+	m.Порт2 = strconv.FormatInt(row.Port2, 10)
+	if p, ok := meta.Get(lgp.TypePort2, row.Port2); ok {
+		m.Порт2 = p.Name
 	}
+	m.Сеанс = strconv.FormatInt(row.Session, 10)
 
-	m.Соединение = res[8]
-	m.Комментарий = res[11]
-	m.Метаданные = res[12]
-	m.Данные = res[13]
-	m.Представление = res[14]
-	m.Порт1 = res[16]
-	m.Порт2 = res[17]
-	m.Сеанс = res[18]
-
-	switch res[10] {
-	case "I":
-		m.Level = "info"
-	case "E":
-		m.Level = "error"
-	case "W":
-		m.Level = "warning"
-	case "N":
-		m.Level = "debug"
-	default:
-		panic("no type")
+	m.Level = lgp.LevelName(row.Level)
+	if m.Level == "" {
+		err = fmt.Errorf("unknown level %q", row.Level)
 	}
-	m.NameDB = f.dir.name
-	m.App = f.dir.app.name
-	m.Folder = f.dir.path
 
+	return
+}
+
+// Message level is not lower than msg_level of source
+func levelAllowed(cfgLevel, level string) bool {
 	sendAllow := false
-	cfgLevel := f.dir.cfg.Section("main").Key("msg_level").MustString("debug")
 	if cfgLevel == "debug" {
 		sendAllow = true
 	}
-	if cfgLevel == "info" && (m.Level == "info" || m.Level == "warning" || m.Level == "error") {
+	if cfgLevel == "info" && (level == "info" || level == "warning" || level == "error") {
 		sendAllow = true
 	}
-	if cfgLevel == "warning" && (m.Level == "warning" || m.Level == "error") {
+	if cfgLevel == "warning" && (level == "warning" || level == "error") {
 		sendAllow = true
 	}
-	if cfgLevel == "error" && m.Level == "error" {
+	if cfgLevel == "error" && level == "error" {
 		sendAllow = true
 	}
-	m.Allow = sendAllow
-
-	return
+	return sendAllow
 }
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"sort"
+	"strings"
+)
+
+// Output is a destination of messages. Every output reads disk queue with
+// own cursor, so it retries and buffers independently of other outputs.
+type Output interface {
+
+	// Open is called once before first Write and retried until success
+	Open() error
+
+	// Write sends batch, on error batch is retried, *PartialError retries only failed messages
+	Write(batch []Message) error
+
+	// Flush makes written messages durable, after it they are removed from queue
+	Flush() error
+
+	Close() error
+}
+
+// Checker is output which can verify endpoint and credentials without writing
+// messages, it is used by log1c check-config
+type Checker interface {
+	Check() error
+}
+
+// Error of batch where only some messages failed
+type PartialError struct {
+	Failed []int
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d messages failed: %v", len(e.Failed), e.Err)
+}
+
+type OutputConfig struct {
+	Name    string
+	Type    string
+	Section *ini.Section
+	App     *App
+}
+
+type outputFactory func(c OutputConfig) (Output, error)
+
+var outputTypes = make(map[string]outputFactory)
+
+func registerOutput(typ string, f outputFactory) {
+	outputTypes[typ] = f
+}
+
+// Outputs from [output "name"] sections, legacy [elastic] section if there are none
+func (a *App) outputConfigs(cfg *ini.File) (list []OutputConfig) {
+
+	for _, section := range cfg.Sections() {
+		name := section.Name()
+		if !strings.HasPrefix(name, "output ") {
+			continue
+		}
+		name = strings.Trim(strings.TrimPrefix(name, "output "), `" `)
+		if !section.Key("enabled").MustBool(true) {
+			continue
+		}
+		list = append(list, OutputConfig{
+			Name:    name,
+			Type:    section.Key("type").MustString(name),
+			Section: section,
+			App:     a,
+		})
+	}
+
+	if len(list) == 0 {
+		list = append(list, OutputConfig{
+			Name:    "elastic",
+			Type:    "elastic",
+			Section: cfg.Section("elastic"),
+			App:     a,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return
+}
+
+func newOutput(c OutputConfig) (Output, error) {
+	f, ok := outputTypes[c.Type]
+	if !ok {
+		return nil, fmt.Errorf("output %s: unknown type %q", c.Name, c.Type)
+	}
+	return f(c)
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Archive of messages in files <path>/<NameDB>/<day>[.N].ndjson[.gz], files
+// are never reopened after close, so written data is immutable
+type ArchiveOutput struct {
+	dir       string
+	compress  bool
+	maxSize   int64
+	maxAge    time.Duration
+	retention int
+	cleaned   time.Time
+	files     map[string]*archiveFile
+}
+
+type archiveFile struct {
+	db     string
+	day    string
+	opened time.Time
+	file   *os.File
+	count  *countWriter
+	buf    *bufio.Writer
+	gz     *gzip.Writer
+	w      io.Writer
+
+	// Write or close failed, messages in buffer did not reach file
+	lost bool
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var (
+	archiveDayRegex  = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(\.\d+)?\.ndjson(\.gz)?$`)
+	archiveNameRegex = regexp.MustCompile(`[^\p{L}\p{N}_.-]+`)
+)
+
+func init() {
+	registerOutput("archive", func(c OutputConfig) (Output, error) {
+		dir := c.Section.Key("path").MustString("archive")
+		if !filepath.IsAbs(dir) {
+			dir = c.App.root + dir
+		}
+		return &ArchiveOutput{
+			dir:       dir,
+			compress:  c.Section.Key("compress").MustBool(true),
+			maxSize:   c.Section.Key("max_size").MustInt64(256 * 1024 * 1024),
+			maxAge:    c.Section.Key("max_age").MustDuration(0),
+			retention: c.Section.Key("retention_days").MustInt(0),
+			files:     make(map[string]*archiveFile),
+		}, nil
+	})
+}
+
+func (o *ArchiveOutput) Open() error {
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return err
+	}
+	o.cleanup()
+	return nil
+}
+
+func (o *ArchiveOutput) Check() error {
+	return checkWritable(o.dir)
+}
+
+// Messages of broken file and the rest of batch are returned in *PartialError,
+// they are written again to new part. Lines which reached broken file before
+// error stay in it, so they could be archived twice.
+func (o *ArchiveOutput) Write(batch []Message) error {
+
+	// Messages of batch which are in buffer of every file
+	written := make(map[*archiveFile][]int)
+
+	for i, msg := range batch {
+
+		f, err := o.file(msg)
+		if err != nil {
+			return o.partial(batch, i, written, err)
+		}
+
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return o.partial(batch, i, written, err)
+		}
+		line = append(line, '\n')
+		if _, err := f.w.Write(line); err != nil {
+			o.discard(f)
+			return o.partial(batch, i, written, err)
+		}
+		written[f] = append(written[f], i)
+
+		// Rotation by size and age
+		if f.count.n+int64(f.buf.Buffered()) >= o.maxSize || o.maxAge > 0 && time.Since(f.opened) >= o.maxAge {
+			if err := o.rotate(f); err != nil {
+				return o.partial(batch, i+1, written, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Messages from i and ones written to lost files
+func (o *ArchiveOutput) partial(batch []Message, i int, written map[*archiveFile][]int, err error) error {
+	var failed []int
+	for f, list := range written {
+		if f.lost {
+			failed = append(failed, list...)
+		}
+	}
+	for ; i < len(batch); i++ {
+		failed = append(failed, i)
+	}
+	sort.Ints(failed)
+	return &PartialError{Failed: failed, Err: err}
+}
+
+// Current file for base and day of message, previous days of base are closed
+func (o *ArchiveOutput) file(msg Message) (*archiveFile, error) {
+
+	db := archiveNameRegex.ReplaceAllString(msg.NameDB, "_")
+	if db == "" {
+		db = "_"
+	}
+	day := msg.ДатаВремя.Format("2006-01-02")
+	key := db + "/" + day
+
+	if f, ok := o.files[key]; ok {
+		return f, nil
+	}
+
+	for _, f := range o.files {
+		if f.db == db && f.day < day {
+			if err := o.rotate(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	f, err := o.create(db, day)
+	if err != nil {
+		return nil, err
+	}
+	o.files[key] = f
+	return f, nil
+}
+
+// New file with free part number
+func (o *ArchiveOutput) create(db, day string) (*archiveFile, error) {
+
+	dir := filepath.Join(o.dir, db)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ext := ".ndjson"
+	if o.compress {
+		ext += ".gz"
+	}
+
+	var path string
+	var file *os.File
+	for part := 0; ; part++ {
+		name := day + ext
+		if part > 0 {
+			name = fmt.Sprintf("%s.%d%s", day, part, ext)
+		}
+		path = filepath.Join(dir, name)
+		var err error
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	f := &archiveFile{
+		db:     db,
+		day:    day,
+		opened: time.Now(),
+		file:   file,
+	}
+	f.count = &countWriter{w: file}
+	f.buf = bufio.NewWriterSize(f.count, 256*1024)
+	f.w = f.buf
+	if o.compress {
+		f.gz = gzip.NewWriter(f.buf)
+		f.w = f.gz
+	}
+	return f, nil
+}
+
+// Close file and sync it to disk, next write of same day goes to new part
+func (o *ArchiveOutput) rotate(f *archiveFile) error {
+	if err := f.close(); err != nil {
+		o.discard(f)
+		return err
+	}
+	delete(o.files, f.db+"/"+f.day)
+	o.cleanup()
+	return nil
+}
+
+// Writers of file keep their error, so broken file is left as is and
+// next write of same day goes to new part
+func (o *ArchiveOutput) discard(f *archiveFile) {
+	f.lost = true
+	_ = f.file.Close()
+	delete(o.files, f.db+"/"+f.day)
+}
+
+func (f *archiveFile) flush() error {
+	if f.gz != nil {
+		if err := f.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *archiveFile) close() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	return f.file.Close()
+}
+
+// Remove files not modified during retention, backfilled old days are kept
+func (o *ArchiveOutput) cleanup() {
+	if o.retention <= 0 {
+		return
+	}
+	o.cleaned = time.Now()
+	border := time.Now().AddDate(0, 0, -o.retention)
+	dbs, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return
+	}
+	for _, db := range dbs {
+		if !db.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(o.dir, db.Name()))
+		if err != nil {
+			continue
+		}
+		for _, ff := range files {
+			res := archiveDayRegex.FindStringSubmatch(ff.Name())
+			if len(res) == 0 || ff.ModTime().After(border) {
+				continue
+			}
+			if _, open := o.files[db.Name()+"/"+res[1]]; open {
+				continue
+			}
+			_ = os.Remove(filepath.Join(o.dir, db.Name(), ff.Name()))
+		}
+	}
+}
+
+func (o *ArchiveOutput) Flush() error {
+	for _, f := range o.files {
+		if err := f.flush(); err != nil {
+			return err
+		}
+	}
+	if time.Since(o.cleaned) > time.Hour {
+		o.cleanup()
+	}
+	return nil
+}
+
+func (o *ArchiveOutput) Close() error {
+	var err error
+	for k, f := range o.files {
+		if cerr := f.close(); err == nil {
+			err = cerr
+		}
+		delete(o.files, k)
+	}
+	return err
+}
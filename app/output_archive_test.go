@@ -0,0 +1,90 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readArchive(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, msg.Комментарий)
+	}
+	return list
+}
+
+func TestArchiveWritePartial(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "log1c-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	o := &ArchiveOutput{dir: dir, maxSize: 1 << 20, files: make(map[string]*archiveFile)}
+
+	day := time.Date(2020, 8, 12, 12, 0, 0, 0, time.UTC)
+	batch := []Message{
+		{NameDB: "base", ДатаВремя: day, Комментарий: "first"},
+		{NameDB: "other", ДатаВремя: day, Комментарий: "other"},
+		{NameDB: "base", ДатаВремя: day, Комментарий: "second"},
+		{NameDB: "base", ДатаВремя: day.AddDate(0, 0, 1), Комментарий: "next day"},
+	}
+
+	// Buffered lines can not reach file, it fails when it is closed for the next day
+	f, err := o.file(batch[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.file.Close()
+
+	err = o.Write(batch)
+	var pe *PartialError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %v, want PartialError", err)
+	}
+	if len(pe.Failed) != 3 || pe.Failed[0] != 0 || pe.Failed[1] != 2 || pe.Failed[2] != 3 {
+		t.Fatalf("failed %v, want [0 2 3]", pe.Failed)
+	}
+
+	// Failed messages are written to new part
+	var failed []Message
+	for _, i := range pe.Failed {
+		failed = append(failed, batch[i])
+	}
+	if err := o.Write(failed); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"base/2020-08-12.ndjson":   nil,
+		"base/2020-08-12.1.ndjson": {"first", "second"},
+		"base/2020-08-13.ndjson":   {"next day"},
+		"other/2020-08-12.ndjson":  {"other"},
+	}
+	for name, lines := range want {
+		got := readArchive(t, filepath.Join(dir, filepath.FromSlash(name)))
+		if strings.Join(got, ",") != strings.Join(lines, ",") {
+			t.Errorf("%s has %q, want %q", name, got, lines)
+		}
+	}
+}
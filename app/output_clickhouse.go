@@ -0,0 +1,202 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ClickHouse through HTTP interface, INSERT ... FORMAT JSONEachRow
+type ClickHouseOutput struct {
+	section *ini.Section
+	client  *http.Client
+	table   string
+	columns []chColumn
+}
+
+type chColumn struct {
+	name  string
+	typ   string
+	field int
+}
+
+func init() {
+	registerOutput("clickhouse", func(c OutputConfig) (Output, error) {
+		db := c.Section.Key("database").MustString("default")
+		table := c.Section.Key("table").MustString("log1c")
+		return &ClickHouseOutput{
+			section: c.Section,
+			table:   chQuote(db) + "." + chQuote(table),
+			columns: messageColumns(),
+		}, nil
+	})
+}
+
+// Table columns from exported fields of Message
+func messageColumns() (list []chColumn) {
+	t := reflect.TypeOf(Message{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		typ := "String"
+		switch {
+		case f.Type == reflect.TypeOf(time.Time{}):
+			typ = "DateTime"
+		case f.Type.Kind() == reflect.Bool:
+			typ = "UInt8"
+		case f.Type.Kind() == reflect.Int || f.Type.Kind() == reflect.Int64:
+			typ = "Int64"
+		case f.Type.Kind() == reflect.Float64:
+			typ = "Float64"
+		}
+		list = append(list, chColumn{name: name, typ: typ, field: i})
+	}
+	return
+}
+
+func chQuote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// Create table and add columns which appeared in Message later
+func (o *ClickHouseOutput) Open() error {
+
+	o.client = &http.Client{
+		Timeout: o.section.Key("timeout").MustDuration(60 * time.Second),
+	}
+
+	if !o.section.Key("create_table").MustBool(true) {
+		return nil
+	}
+
+	var cols, alter []string
+	for _, c := range o.columns {
+		cols = append(cols, chQuote(c.name)+" "+c.typ)
+		alter = append(alter, "ADD COLUMN IF NOT EXISTS "+chQuote(c.name)+" "+c.typ)
+	}
+
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = %s PARTITION BY toYYYYMM(%s) ORDER BY (%s, %s)",
+		o.table,
+		strings.Join(cols, ", "),
+		o.section.Key("engine").MustString("MergeTree"),
+		chQuote("ДатаВремя"),
+		chQuote("NameDB"),
+		chQuote("ДатаВремя"),
+	)
+	if err := o.exec(create, nil); err != nil {
+		return err
+	}
+
+	return o.exec(fmt.Sprintf("ALTER TABLE %s %s", o.table, strings.Join(alter, ", ")), nil)
+}
+
+// Table is not created by check, it has to exist if create_table is off
+func (o *ClickHouseOutput) Check() error {
+	o.client = &http.Client{
+		Timeout: o.section.Key("timeout").MustDuration(60 * time.Second),
+	}
+	defer o.Close()
+	if !o.section.Key("create_table").MustBool(true) {
+		return o.exec(fmt.Sprintf("SELECT 1 FROM %s LIMIT 0", o.table), nil)
+	}
+	return o.exec("SELECT 1", nil)
+}
+
+func (o *ClickHouseOutput) Write(batch []Message) error {
+
+	var buf bytes.Buffer
+	for _, msg := range batch {
+		row := make(map[string]interface{}, len(o.columns))
+		v := reflect.ValueOf(msg)
+		for _, c := range o.columns {
+			row[c.name] = chValue(v.Field(c.field))
+		}
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return o.exec(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", o.table), &buf)
+}
+
+// Value of field in form accepted by column type
+func chValue(v reflect.Value) interface{} {
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Unix()
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	case string, int, int64, float64:
+		return x
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (o *ClickHouseOutput) exec(query string, body io.Reader) error {
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("input_format_skip_unknown_fields", "1")
+	uri := strings.TrimRight(o.section.Key("url").MustString("http://127.0.0.1:8123"), "/") + "/?" + params.Encode()
+
+	if body == nil {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest("POST", uri, body)
+	if err != nil {
+		return err
+	}
+	if user := o.section.Key("user").String(); user != "" {
+		req.SetBasicAuth(user, o.section.Key("pass").String())
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		text, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("clickhouse status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (o *ClickHouseOutput) Flush() error {
+	return nil
+}
+
+func (o *ClickHouseOutput) Close() error {
+	if o.client != nil {
+		o.client.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"encoding/json"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Request received by stand-in of ClickHouse HTTP interface
+type chRequest struct {
+	query string
+	body  string
+	user  string
+	pass  string
+}
+
+func newClickHouseStandIn(t *testing.T) (*httptest.Server, func() []chRequest) {
+	var mu sync.Mutex
+	var list []chRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		user, pass, _ := r.BasicAuth()
+		mu.Lock()
+		list = append(list, chRequest{query: r.URL.Query().Get("query"), body: string(body), user: user, pass: pass})
+		mu.Unlock()
+	}))
+	return srv, func() []chRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]chRequest(nil), list...)
+	}
+}
+
+func newTestClickHouse(t *testing.T, url string) Output {
+	cfg := ini.Empty()
+	section := cfg.Section("output \"ch\"")
+	section.Key("type").SetValue("clickhouse")
+	section.Key("url").SetValue(url)
+	section.Key("database").SetValue("logs")
+	section.Key("user").SetValue("writer")
+	section.Key("pass").SetValue("secret")
+	output, err := newOutput(OutputConfig{Name: "ch", Type: "clickhouse", Section: section, App: &App{cfg: cfg}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestClickHouseOpenCreatesTable(t *testing.T) {
+
+	srv, requests := newClickHouseStandIn(t)
+	defer srv.Close()
+
+	output := newTestClickHouse(t, srv.URL)
+	if err := output.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	list := requests()
+	if len(list) != 2 {
+		t.Fatalf("got %d requests, want CREATE and ALTER", len(list))
+	}
+
+	create := list[0].query
+	for _, want := range []string{
+		"CREATE TABLE IF NOT EXISTS `logs`.`log1c` (",
+		"`ДатаВремя` DateTime",
+		"`ПользовательИд` Int64",
+		"`Allow` UInt8",
+		"`Комментарий` String",
+		"ENGINE = MergeTree",
+		"PARTITION BY toYYYYMM(`ДатаВремя`)",
+		"ORDER BY (`NameDB`, `ДатаВремя`)",
+	} {
+		if !strings.Contains(create, want) {
+			t.Errorf("CREATE has no %q: %s", want, create)
+		}
+	}
+	if strings.Contains(create, "`store`") {
+		t.Errorf("CREATE has unexported field: %s", create)
+	}
+
+	alter := list[1].query
+	if !strings.HasPrefix(alter, "ALTER TABLE `logs`.`log1c` ADD COLUMN IF NOT EXISTS ") {
+		t.Errorf("unexpected ALTER: %s", alter)
+	}
+	if !strings.Contains(alter, "ADD COLUMN IF NOT EXISTS `ДатаВремяUTC` DateTime") {
+		t.Errorf("ALTER has no column of ДатаВремяUTC: %s", alter)
+	}
+
+	for _, r := range list {
+		if r.user != "writer" || r.pass != "secret" {
+			t.Errorf("credentials %q:%q, want writer:secret", r.user, r.pass)
+		}
+	}
+}
+
+func TestClickHouseWriteInsertsRows(t *testing.T) {
+
+	srv, requests := newClickHouseStandIn(t)
+	defer srv.Close()
+
+	output := newTestClickHouse(t, srv.URL)
+	if err := output.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	at := time.Date(2020, 8, 12, 12, 1, 5, 0, time.FixedZone("MSK", 3*3600))
+	batch := []Message{
+		{ID: "1", Allow: true, Level: "error", NameDB: "buh", ДатаВремя: at, Пользователь: "Admin", ПользовательИд: 1, Комментарий: "multi\nline"},
+		{ID: "2", Level: "info", NameDB: "buh", ДатаВремя: at.Add(time.Second), Свойства: map[string]string{"Usr": "Admin"}},
+	}
+	if err := output.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	list := requests()
+	insert := list[len(list)-1]
+	if insert.query != "INSERT INTO `logs`.`log1c` FORMAT JSONEachRow" {
+		t.Fatalf("unexpected query: %s", insert.query)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(insert.body, "\n"), "\n")
+	if len(lines) != len(batch) {
+		t.Fatalf("got %d rows, want %d: %s", len(lines), len(batch), insert.body)
+	}
+	var rows []map[string]interface{}
+	for _, line := range lines {
+		var row map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("row %s: %v", line, err)
+		}
+		rows = append(rows, row)
+	}
+
+	// DateTime is unix time, bool is 0 or 1, maps are JSON strings
+	checks := []struct {
+		row   int
+		field string
+		want  interface{}
+	}{
+		{0, "ДатаВремя", float64(at.Unix())},
+		{0, "Allow", float64(1)},
+		{0, "ПользовательИд", float64(1)},
+		{0, "Комментарий", "multi\nline"},
+		{0, "NameDB", "buh"},
+		{1, "Allow", float64(0)},
+		{1, "Свойства", `{"Usr":"Admin"}`},
+	}
+	for _, c := range checks {
+		if got := rows[c.row][c.field]; got != c.want {
+			t.Errorf("row %d %s = %#v, want %#v", c.row, c.field, got, c.want)
+		}
+	}
+	if _, ok := rows[0]["store"]; ok {
+		t.Errorf("row has unexported field: %s", lines[0])
+	}
+}
+
+func TestClickHouseStatusError(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Code: 516. Authentication failed", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	output := newTestClickHouse(t, srv.URL)
+	err := output.Open()
+	if err == nil || !strings.Contains(err.Error(), "clickhouse status 401") {
+		t.Fatalf("got %v, want status error", err)
+	}
+}
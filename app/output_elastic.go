@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/moskvorechie/logs"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type BasicAuthTransport struct {
+	*http.Transport
+	Username string
+	Password string
+}
+
+func (t BasicAuthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.SetBasicAuth(t.Username, t.Password)
+	return t.Transport.RoundTrip(r)
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Index by month of event, pattern is set by index of output or source
+const elasticIndex = "beat_log1c_{2006.01}"
+
+// Elastic or OpenSearch through Bulk API, indices are typeless
+type ElasticOutput struct {
+	logger  logs.Log
+	section *ini.Section
+	client  *http.Client
+	test    bool
+	index   string
+	source  func(name string) *Source
+}
+
+func init() {
+	registerOutput("elastic", func(c OutputConfig) (Output, error) {
+		return &ElasticOutput{
+			logger:  c.App.logger,
+			section: c.Section,
+			test:    c.App.cfg.Section("main").Key("test").MustBool(),
+			index:   c.Section.Key("index").MustString(elasticIndex),
+			source:  c.App.source,
+		}, nil
+	})
+}
+
+func (o *ElasticOutput) Open() error {
+	o.client = &http.Client{
+		Timeout: o.section.Key("timeout").MustDuration(60 * time.Second),
+		Transport: BasicAuthTransport{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			Username: o.section.Key("user").String(),
+			Password: o.section.Key("pass").String(),
+		},
+	}
+	return nil
+}
+
+// Cluster info is read with credentials of output
+func (o *ElasticOutput) Check() error {
+	uri := strings.TrimRight(o.section.Key("url").String(), "/")
+	if uri == "" {
+		return fmt.Errorf("url is not set")
+	}
+	if err := o.Open(); err != nil {
+		return err
+	}
+	defer o.Close()
+	resp, err := o.client.Get(uri + "/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		text, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("elastic status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	}
+	return nil
+}
+
+// One bulk request, failed items which can be retried are returned in *PartialError
+func (o *ElasticOutput) Write(batch []Message) error {
+
+	if o.test {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range batch {
+		if err := o.bulkItem(&buf, msg); err != nil {
+			return err
+		}
+	}
+
+	// Generate request
+	uri := strings.TrimRight(o.section.Key("url").String(), "/") + "/_bulk"
+	req, err := http.NewRequest("POST", uri, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	// Send request
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("bulk status %d: %s", resp.StatusCode, body)
+	}
+
+	var res bulkResponse
+	if err = json.Unmarshal(body, &res); err != nil {
+		return err
+	}
+	if len(res.Items) != len(batch) {
+		return fmt.Errorf("bulk response has %d items, sent %d", len(res.Items), len(batch))
+	}
+
+	// Items are in the same order as sent
+	statuses := make(map[int]int64)
+	var failed []int
+	var lastErr json.RawMessage
+	for i, action := range res.Items {
+		for _, r := range action {
+			statuses[r.Status]++
+			switch {
+			case r.Status >= 200 && r.Status < 300:
+				o.logger.DebugF("Sent %s %d", batch[i].ID, r.Status)
+			case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+				failed = append(failed, i)
+				lastErr = r.Error
+			default:
+
+				// Document is rejected, retry will not help
+				o.logger.ErrorF("Msg %s rejected %d: %s", batch[i].ID, r.Status, r.Error)
+			}
+		}
+	}
+
+	o.logger.DebugF("Bulk statuses %v", statuses)
+
+	if len(failed) > 0 {
+		return &PartialError{Failed: failed, Err: fmt.Errorf("bulk item error: %s", lastErr)}
+	}
+	return nil
+}
+
+// Action and document lines of message
+func (o *ElasticOutput) bulkItem(buf *bytes.Buffer, msg Message) error {
+
+	// Body
+	doc, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	pattern := o.index
+	if src := o.source(msg.Source); src != nil && src.Index != "" {
+		pattern = src.Index
+	}
+	index := formatIndex(pattern, msg)
+	action, err := json.Marshal(map[string]map[string]string{
+		"index": {"_index": index, "_id": msg.ID},
+	})
+	if err != nil {
+		return err
+	}
+
+	buf.Write(action)
+	buf.WriteByte('\n')
+	buf.Write(doc)
+	buf.WriteByte('\n')
+	return nil
+}
+
+func (o *ElasticOutput) Flush() error {
+	return nil
+}
+
+func (o *ElasticOutput) Close() error {
+	if o.client != nil {
+		o.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Index name from pattern, {source}, {db}, {app} and {log} are fields of
+// message, other names in braces are Go layouts of event time like {2006.01}
+func formatIndex(pattern string, msg Message) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(pattern, '{')
+		j := strings.IndexByte(pattern, '}')
+		if i < 0 || j < i {
+			break
+		}
+		b.WriteString(pattern[:i])
+		switch name := pattern[i+1 : j]; name {
+		case "source":
+			b.WriteString(msg.Source)
+		case "db":
+			b.WriteString(msg.NameDB)
+		case "app":
+			b.WriteString(msg.App)
+		case "log":
+			b.WriteString(msg.Log)
+		default:
+			b.WriteString(msg.ДатаВремя.Format(name))
+		}
+		pattern = pattern[j+1:]
+	}
+	b.WriteString(pattern)
+
+	// Elastic accepts only lowercase names
+	return strings.ToLower(b.String())
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/moskvorechie/logs"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Grafana Loki push API, labels are taken from message fields, other fields go to line
+type LokiOutput struct {
+	logger      logs.Log
+	section     *ini.Section
+	client      *http.Client
+	labels      []lokiLabel
+	static      map[string]string
+	compression string
+}
+
+type lokiLabel struct {
+	name  string
+	field string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	key     string
+	entries []lokiEntry
+}
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func init() {
+	registerOutput("loki", func(c OutputConfig) (Output, error) {
+		o := &LokiOutput{
+			logger:      c.App.logger,
+			section:     c.Section,
+			static:      make(map[string]string),
+			compression: c.Section.Key("compression").MustString("gzip"),
+		}
+
+		// Label sets are limited to avoid cardinality explosion
+		labels := c.Section.Key("labels").MustString("app:App, db:NameDB, level:Level, event:Событие")
+		for _, pair := range strings.Split(labels, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+			if len(parts) != 2 || !lokiLabelName.MatchString(parts[0]) {
+				return nil, fmt.Errorf("output %s: bad label %q, want name:Field", c.Name, pair)
+			}
+			o.labels = append(o.labels, lokiLabel{name: parts[0], field: strings.TrimSpace(parts[1])})
+		}
+		for _, pair := range strings.Split(c.Section.Key("static_labels").String(), ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if !lokiLabelName.MatchString(parts[0]) {
+				return nil, fmt.Errorf("output %s: bad static label %q", c.Name, pair)
+			}
+			o.static[parts[0]] = strings.TrimSpace(parts[1])
+		}
+
+		switch o.compression {
+		case "gzip", "snappy", "none":
+		default:
+			return nil, fmt.Errorf("output %s: unknown compression %q", c.Name, o.compression)
+		}
+
+		return o, nil
+	})
+}
+
+func (o *LokiOutput) Open() error {
+	o.client = &http.Client{
+		Timeout: o.section.Key("timeout").MustDuration(60 * time.Second),
+	}
+	return nil
+}
+
+func (o *LokiOutput) Write(batch []Message) error {
+
+	streams, err := o.streams(batch)
+	if err != nil {
+		return err
+	}
+
+	// Snappy is supported by Loki only for protobuf body
+	var body []byte
+	req, err := o.pushRequest()
+	if err != nil {
+		return err
+	}
+	switch o.compression {
+	case "snappy":
+		body = snappy.Encode(nil, lokiProto(streams))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(lokiJSON(streams)); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+	default:
+		body = lokiJSON(streams)
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	text, _ := ioutil.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("loki status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	}
+
+	// Entries are rejected (too old, out of order), retry will not help
+	o.logger.ErrorF("%d messages rejected, loki status %d: %s", len(batch), resp.StatusCode, bytes.TrimSpace(text))
+	return nil
+}
+
+// Push request with credentials and tenant, body is set by caller
+func (o *LokiOutput) pushRequest() (*http.Request, error) {
+	req, err := http.NewRequest("POST", strings.TrimRight(o.section.Key("url").MustString("http://127.0.0.1:3100"), "/")+"/loki/api/v1/push", nil)
+	if err != nil {
+		return nil, err
+	}
+	if user := o.section.Key("user").String(); user != "" {
+		req.SetBasicAuth(user, o.section.Key("pass").String())
+	}
+	if tenant := o.section.Key("tenant").String(); tenant != "" {
+		req.Header.Set("X-Scope-OrgID", tenant)
+	}
+	return req, nil
+}
+
+// Push without streams checks endpoint, credentials and tenant
+func (o *LokiOutput) Check() error {
+	if err := o.Open(); err != nil {
+		return err
+	}
+	defer o.Close()
+	req, err := o.pushRequest()
+	if err != nil {
+		return err
+	}
+	body := []byte(`{"streams":[]}`)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		text, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("loki status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	}
+	return nil
+}
+
+// Group messages by label values, entries of stream are sorted by time
+func (o *LokiOutput) streams(batch []Message) ([]*lokiStream, error) {
+
+	index := make(map[string]*lokiStream)
+	var list []*lokiStream
+
+	for _, msg := range batch {
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+
+		labels := make(map[string]string, len(o.labels)+len(o.static))
+		for k, v := range o.static {
+			labels[k] = v
+		}
+		for _, l := range o.labels {
+			if v, ok := fields[l.field]; ok {
+				labels[l.name] = fmt.Sprint(v)
+				delete(fields, l.field)
+			}
+		}
+		line, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+
+		key := lokiLabelsString(labels)
+		s, ok := index[key]
+		if !ok {
+			s = &lokiStream{labels: labels, key: key}
+			index[key] = s
+			list = append(list, s)
+		}
+		s.entries = append(s.entries, lokiEntry{ts: msg.ДатаВремя, line: string(line)})
+	}
+
+	for _, s := range list {
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			return s.entries[i].ts.Before(s.entries[j].ts)
+		})
+	}
+
+	return list, nil
+}
+
+// Labels in Prometheus format {a="1", b="2"}
+func lokiLabelsString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, k := range names {
+		parts = append(parts, k+"="+strconv.Quote(labels[k]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func lokiJSON(streams []*lokiStream) []byte {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	req := struct {
+		Streams []stream `json:"streams"`
+	}{}
+	for _, s := range streams {
+		st := stream{Stream: s.labels}
+		for _, e := range s.entries {
+			st.Values = append(st.Values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+		}
+		req.Streams = append(req.Streams, st)
+	}
+	data, _ := json.Marshal(req)
+	return data
+}
+
+// logproto.PushRequest, streams = 1 {labels = 1, entries = 2 {timestamp = 1 {seconds = 1, nanos = 2}, line = 2}}
+func lokiProto(streams []*lokiStream) []byte {
+	var req []byte
+	for _, s := range streams {
+		var st []byte
+		st = protowire.AppendTag(st, 1, protowire.BytesType)
+		st = protowire.AppendString(st, s.key)
+		for _, e := range s.entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.ts.Unix()))
+			if nanos := e.ts.Nanosecond(); nanos > 0 {
+				ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+				ts = protowire.AppendVarint(ts, uint64(nanos))
+			}
+
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendBytes(entry, ts)
+			entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+			entry = protowire.AppendString(entry, e.line)
+
+			st = protowire.AppendTag(st, 2, protowire.BytesType)
+			st = protowire.AppendBytes(st, entry)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, st)
+	}
+	return req
+}
+
+func (o *LokiOutput) Flush() error {
+	return nil
+}
+
+func (o *LokiOutput) Close() error {
+	if o.client != nil {
+		o.client.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Messages as json lines appended to one local file
+type NDJSONOutput struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+}
+
+func init() {
+	registerOutput("ndjson", func(c OutputConfig) (Output, error) {
+		path := c.Section.Key("path").String()
+		if path == "" {
+			return nil, fmt.Errorf("output %s: path is empty", c.Name)
+		}
+		if !filepath.IsAbs(path) {
+			path = c.App.root + path
+		}
+		return &NDJSONOutput{path: path}, nil
+	})
+}
+
+func (o *NDJSONOutput) Open() (err error) {
+	if err = os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return
+	}
+	o.file, err = os.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	o.w = bufio.NewWriterSize(o.file, 256*1024)
+	return
+}
+
+// File is not created by check
+func (o *NDJSONOutput) Check() error {
+	if _, err := os.Stat(o.path); err == nil {
+		file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+	return checkWritable(filepath.Dir(o.path))
+}
+
+func (o *NDJSONOutput) Write(batch []Message) error {
+	for _, msg := range batch {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		o.w.Write(line)
+		if err := o.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *NDJSONOutput) Flush() error {
+	if err := o.w.Flush(); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+func (o *NDJSONOutput) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	err := o.w.Flush()
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long stopped reader waits for its records to reach spool
+const drainTimeout = 30 * time.Second
+
+// Reader of source which can be stopped alone
+type runningSource struct {
+	src   *Source
+	exit  chan bool
+	done  chan struct{}
+	save  func()
+	drain func(timeout time.Duration) bool
+}
+
+// Sender of output which can be stopped alone
+type runningSender struct {
+	config string
+	exit   chan bool
+	done   chan struct{}
+}
+
+// Where reader of event log starts when it has no checkpoint
+type startMode int
+
+const (
+	// End of the newest file
+	startTail startMode = iota
+	// Beginning of the oldest file, dir appeared after start of service
+	startFirst
+	// History of [backfill] period first, only readers started with service
+	startBackfill
+)
+
+// Start reader of source, it is not started after exit
+func (a *App) startSource(src *Source, mode startMode) {
+
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+	select {
+	case <-a.exit:
+		return
+	default:
+	}
+
+	rs := &runningSource{src: src, exit: make(chan bool), done: make(chan struct{})}
+	var run func()
+	switch src.Format {
+	case formatTechLog:
+		r := a.newTechLogReader(src, rs.exit)
+		run, rs.save, rs.drain = r.Run, r.saveCheckpoint, r.drainCheckpoint
+	default:
+		r := a.newDirReader(src, rs.exit)
+		r.fromStart = mode == startFirst
+		r.backfill = mode == startBackfill && a.backfill.Enabled
+		run, rs.save, rs.drain = r.Run, r.saveCheckpoint, r.drainCheckpoint
+	}
+	a.running[src.Name] = rs
+	a.rwg.Add(1)
+	go func() {
+		defer close(rs.done)
+		run()
+	}()
+}
+
+// Stop reader and wait until it saves checkpoint. Messages read before stop
+// are acknowledged by spool later, their position is saved before reader of
+// the same source starts again, otherwise they are read twice.
+func (a *App) stopSource(name string) {
+	a.runMu.Lock()
+	rs, ok := a.running[name]
+	delete(a.running, name)
+	a.runMu.Unlock()
+	if !ok {
+		return
+	}
+	close(rs.exit)
+	<-rs.done
+	if !rs.drain(drainTimeout) {
+		a.logger.WarnF("Source %s: messages are not written to spool in %s, they could be read again", name, drainTimeout)
+	}
+	rs.save()
+}
+
+// Sender reads queue from last commit, messages read by previous one are sent again
+func (a *App) startSender(oc OutputConfig, output Output) error {
+	consumer, err := a.queue.Consumer(oc.Name)
+	if err != nil {
+		return err
+	}
+	consumer.Rewind()
+
+	// Restarted sender opens output again
+	state := a.outputState(oc.Name)
+	state.mu.Lock()
+	state.typ, state.queue, state.open = oc.Type, consumer, false
+	state.mu.Unlock()
+
+	rs := &runningSender{config: sectionString(a.pristine.Section(oc.Section.Name())), exit: make(chan bool), done: make(chan struct{})}
+	a.senders[oc.Name] = rs
+	a.wg.Add(1)
+	var s Sender
+	s.logger = a.logger
+	s.queue = consumer
+	s.output = output
+	s.cfg = a.cfg
+	s.section = oc.Section
+	s.source = a.source
+	s.state = state
+	s.sourceState = a.sourceState
+	s.wg = a.wg
+	s.exit = rs.exit
+	go func() {
+		defer close(rs.done)
+		s.Run()
+	}()
+	return nil
+}
+
+func (a *App) stopSender(name string) {
+	rs, ok := a.senders[name]
+	if !ok {
+		return
+	}
+	delete(a.senders, name)
+	close(rs.exit)
+	<-rs.done
+}
+
+// Reload reads app.ini again and applies difference: readers and senders of
+// changed sources and outputs are restarted, levels and filters of sources are
+// replaced in place. Config with errors is not applied.
+func (a *App) Reload() error {
+
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	select {
+	case <-a.exit:
+		return fmt.Errorf("app is stopping")
+	default:
+	}
+
+	data, err := ioutil.ReadFile(a.root + "app.ini")
+	if err != nil {
+		return err
+	}
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return err
+	}
+	pristine, err := ini.Load(data)
+	if err != nil {
+		return err
+	}
+	sources, err := a.sourceConfigs(cfg)
+	if err != nil {
+		return err
+	}
+	outputs := a.outputConfigs(cfg)
+	if err := checkSourceOutputs(sources, outputs); err != nil {
+		return err
+	}
+
+	// Readers of new and moved sources would not start with wrong path
+	for _, src := range sources {
+		if prev := a.source(src.Name); prev != nil && !prev.discovered && prev.sameReader(src) {
+			continue
+		}
+		if _, err := checkSourceDir(src); err != nil {
+			return fmt.Errorf("source %s: %v", src.Name, err)
+		}
+	}
+
+	// New and changed outputs are created before anything is stopped
+	created := make(map[string]Output)
+	for _, oc := range outputs {
+		if rs, ok := a.senders[oc.Name]; ok && rs.config == sectionString(pristine.Section(oc.Section.Name())) {
+			continue
+		}
+		output, err := newOutput(oc)
+		if err != nil {
+			return err
+		}
+		created[oc.Name] = output
+	}
+
+	// Settings which are read only on start
+	old := restartSettings(a.pristine)
+	for name, value := range restartSettings(pristine) {
+		if old[name] != value {
+			a.logger.WarnF("Reload: %s is applied after restart", name)
+		}
+	}
+
+	a.cfgMu.Lock()
+	a.cfg = cfg
+	a.cfgMu.Unlock()
+	a.pristine = pristine
+	a.setLogLevel(cfg)
+
+	// Outputs
+	names := make(map[string]bool)
+	for _, oc := range outputs {
+		names[oc.Name] = true
+	}
+	for name := range a.senders {
+		if names[name] {
+			continue
+		}
+		a.logger.InfoF("Reload: output %s is removed", name)
+		a.stopSender(name)
+		a.deleteOutputState(name)
+		if err := a.queue.RemoveConsumer(name); err != nil {
+			a.logger.LogError(err)
+		}
+	}
+	for _, oc := range outputs {
+		output, ok := created[oc.Name]
+		if !ok {
+			continue
+		}
+		if _, ok := a.senders[oc.Name]; ok {
+			a.logger.InfoF("Reload: output %s is changed", oc.Name)
+			a.stopSender(oc.Name)
+		} else {
+			a.logger.InfoF("Reload: output %s is added", oc.Name)
+		}
+		if err := a.startSender(oc, output); err != nil {
+			a.logger.LogError(err)
+		}
+	}
+
+	// Sources, discovered ones are left to discovery
+	names = make(map[string]bool)
+	for _, src := range sources {
+		names[src.Name] = true
+	}
+	a.sourcesMu.RLock()
+	var removed []string
+	for name, src := range a.sources {
+		if !src.discovered && !names[name] {
+			removed = append(removed, name)
+		}
+	}
+	a.sourcesMu.RUnlock()
+	for _, name := range removed {
+		a.logger.InfoF("Reload: source %s is removed", name)
+		a.stopSource(name)
+		a.deleteSource(name)
+	}
+	for _, src := range sources {
+		prev := a.source(src.Name)
+		switch {
+		case prev == nil:
+			a.logger.InfoF("Reload: source %s is added", src.Name)
+			a.setSource(src)
+			a.startSource(src, startTail)
+		case prev.discovered || !prev.sameReader(src):
+			a.logger.InfoF("Reload: source %s is changed, reader is restarted", src.Name)
+			a.stopSource(src.Name)
+			a.setSource(src)
+			a.startSource(src, startTail)
+		default:
+			a.setSource(src)
+		}
+	}
+
+	// Discovery reads its settings every time it looks into registry
+	if cfg.Section("discovery").Key("enabled").MustBool() {
+		a.startDiscovery()
+	}
+	select {
+	case a.discoveryWake <- struct{}{}:
+	default:
+	}
+
+	a.logger.Info("Config is reloaded")
+	return nil
+}
+
+// Settings which are not applied by reload
+func restartSettings(cfg *ini.File) map[string]string {
+	m := map[string]string{
+		"[spool]":    sectionString(cfg.Section("spool")),
+		"[backfill]": sectionString(cfg.Section("backfill")),
+	}
+	for _, key := range []string{"app", "log_path", "checkpoint_path", "watch", "watch_interval", "test"} {
+		m["[main] "+key] = cfg.Section("main").Key(key).String()
+	}
+	return m
+}
+
+// All keys of section to find changes
+func sectionString(section *ini.Section) string {
+	var sb strings.Builder
+	for _, key := range section.Keys() {
+		sb.WriteString(key.Name())
+		sb.WriteByte('=')
+		sb.WriteString(key.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// SIGHUP is never sent on Windows, there config is reloaded by POST /reload
+func (a *App) watchReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-a.exit:
+			return
+		case <-ch:
+			if err := a.Reload(); err != nil {
+				a.logger.ErrorF("Reload error: %v", err)
+			}
+		}
+	}
+}
+
+// POST /reload, allowed from localhost or with [main] admin_token in Authorization: Bearer header
+func (a *App) reloadHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.adminAllowed(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := a.Reload(); err != nil {
+		a.logger.ErrorF("Reload error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "reloaded")
+}
+
+func (a *App) adminAllowed(req *http.Request) bool {
+	if token := a.config().Section("main").Key("admin_token").String(); token != "" {
+		return req.Header.Get("Authorization") == "Bearer "+token
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
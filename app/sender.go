@@ -1,38 +1,32 @@
 package app
 
 import (
-	"bytes"
-	"crypto/tls"
 	"encoding/json"
-	"fmt"
+	"github.com/moskvorechie/log1c/spool"
 	"github.com/moskvorechie/logs"
 	"gopkg.in/ini.v1"
-	"net/http"
 	"runtime/debug"
 	"sync"
 	"time"
 )
 
+// Sender moves messages from disk queue to one output
 type Sender struct {
-	wg     *sync.WaitGroup
-	cfg    *ini.File
-	exit   chan bool
-	mess   chan Message
-	logger logs.Log
+	wg      *sync.WaitGroup
+	cfg     *ini.File
+	section *ini.Section
+	exit    chan bool
+	queue   *spool.Consumer
+	output  Output
+	logger  logs.Log
+	source  func(name string) *Source
+
+	// Times of send and errors for /status
+	state       *outputState
+	sourceState func(name string) *sourceState
 }
 
-type BasicAuthTransport struct {
-	*http.Transport
-	Username string
-	Password string
-}
-
-func (t BasicAuthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
-	r.SetBasicAuth(t.Username, t.Password)
-	return t.Transport.RoundTrip(r)
-}
-
-func (s *Sender) Run(k int) {
+func (s *Sender) Run() {
 
 	defer func() {
 		if rc := recover(); rc != nil {
@@ -41,104 +35,152 @@ func (s *Sender) Run(k int) {
 		}
 	}()
 
-	client := &http.Client{
-		Timeout: 15 * time.Second,
-		Transport: BasicAuthTransport{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-			Username: s.cfg.Section("elastic").Key("user").String(),
-			Password: s.cfg.Section("elastic").Key("pass").String(),
-		},
-	}
+	defer s.wg.Done()
 
-	var req *http.Request
-	var resp *http.Response
+	// Set output to logger
+	s.logger.SetCustomLogger(s.logger.Logger().With().Str("output", s.queue.Name()).Logger().Hook(s.state.hook()))
 
-	defer s.wg.Done()
+	// Batch limits
+	maxCount := s.section.Key("bulk_size").MustInt(500)
+	maxBytes := s.section.Key("bulk_bytes").MustInt(5 * 1024 * 1024)
+	interval := s.section.Key("bulk_interval").MustDuration(5 * time.Second)
+
+	appName := s.cfg.Section("main").Key("app").String()
 
-	s.logger.InfoF("Sender %d start", k)
-	defer s.logger.InfoF("Sender %d stop", k)
+	s.logger.Info("Sender start")
+	defer s.logger.Info("Sender stop")
 
-	var count int64
-	statuses := make(map[int]int64)
+	// Output could be not available yet
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && !s.wait(attempt) {
+			return
+		}
+		err := s.output.Open()
+		if err == nil {
+			s.state.opened()
+			break
+		}
+		s.logger.WarnF("Retry open: attempt %d | err %v", attempt, err)
+	}
+	defer func() {
+		if err := s.output.Close(); err != nil {
+			s.logger.LogError(err)
+		}
+	}()
 
 	for {
+
+		// Batch from disk queue
+		records, pos, err := s.queue.Read(maxCount, maxBytes, interval, s.exit)
+		if err == spool.ErrClosed {
+			return
+		}
+		if err != nil {
+			s.logger.FatalError(err)
+		}
+		metricSpoolDepth.WithLabelValues(appName, s.queue.Name()).Set(float64(s.queue.Depth()))
+
+		// Read records are sent after restart
 		select {
-		case msg, ok := <-s.mess:
-			if !ok {
-				return
-			}
+		case <-s.exit:
+			return
+		default:
+		}
 
-			count++
+		if len(records) == 0 {
+			continue
+		}
 
-			// Body
-			body, err := json.Marshal(msg)
-			if err != nil {
-				s.logger.FatalF("%v", err)
+		batch := make([]Message, 0, len(records))
+		for _, rec := range records {
+			var msg Message
+			if err := json.Unmarshal(rec, &msg); err != nil {
+				s.logger.ErrorF("Spool record error: %v: %s", err, rec)
+				continue
 			}
 
-			index := "beat_log1c_" + msg.ДатаВремя.Format("2006.01")
-			buf := bytes.NewBuffer(body)
-
-			// Max attempt if lose connection
-			for attempt := 0; ; attempt++ {
-
-				if s.cfg.Section("main").Key("test").MustBool() {
-					resp = &http.Response{
-						StatusCode: http.StatusOK,
-					}
-					break
-				}
-
-				// Generate request
-				uri := fmt.Sprintf("%s/%s/job/%s", s.cfg.Section("elastic").Key("url").String(), index, msg.ID)
-				req, err = http.NewRequest("POST", uri, buf)
-				if err != nil {
-					s.logger.FatalF("%v", err)
-				}
-				req.Header.Set("Content-Type", "application/json")
-
-				// Send request
-				resp, err = client.Do(req)
-				if resp == nil {
-					s.logger.WarnF("Retry send: attempt %d | resp nil", attempt)
-					time.Sleep(time.Duration(attempt*2) * time.Second)
-					continue
-				}
-				resp.Body.Close()
-				if err != nil || resp.StatusCode > 300 {
-					if resp.StatusCode > 300 {
-						s.logger.WarnF("%v", resp)
-					}
-					if attempt >= 10 {
-						s.logger.ErrorF("Msg %+v", msg)
-						s.logger.ErrorF("Uri %+v", uri)
-						s.logger.Error("Max attempt to send message")
-						break
-					} else {
-						s.logger.WarnF("Retry send: attempt %d | err %v", attempt, err)
-						err = nil
-						time.Sleep(time.Duration(attempt*2) * time.Second)
-						continue
-					}
-				}
-				break
+			// Messages of sources routed to other outputs are skipped
+			if !s.source(msg.Source).routed(s.queue.Name()) {
+				continue
 			}
+			batch = append(batch, msg)
+		}
 
-			s.logger.DebugF("Sent %s %d", msg.ID, resp.StatusCode)
+		// Not sent messages stay in queue until restart
+		if len(batch) > 0 && !s.send(batch) {
+			return
+		}
+		if err := s.queue.Commit(pos); err != nil {
+			s.logger.LogError(err)
+		}
+		metricSpoolDepth.WithLabelValues(appName, s.queue.Name()).Set(float64(s.queue.Depth()))
+	}
+}
 
-			statuses[resp.StatusCode]++
+// Write batch to output, only failed messages are retried until success or exit
+func (s *Sender) send(batch []Message) bool {
 
-			if count >= 100 {
+	count := len(batch)
+	var sentSources []string
+	for _, msg := range batch {
+		if len(sentSources) == 0 || sentSources[len(sentSources)-1] != msg.Source {
+			sentSources = append(sentSources, msg.Source)
+		}
+	}
 
-				s.logger.InfoF("Sent 100 rows, statuses %v", statuses)
+	// Retry while output is not available, messages wait in queue
+	for attempt := 0; len(batch) > 0; attempt++ {
+		if attempt > 0 && !s.wait(attempt) {
+			return false
+		}
 
-				count = 0
-				statuses = make(map[int]int64)
+		err := s.output.Write(batch)
+		if err == nil {
+			break
+		}
+		if pe, ok := err.(*PartialError); ok {
+			failed := make([]Message, 0, len(pe.Failed))
+			for _, i := range pe.Failed {
+				failed = append(failed, batch[i])
 			}
-		case <-s.exit:
-			return
+			s.logger.WarnF("Retry send: attempt %d | %d of %d messages failed | err %v", attempt, len(failed), len(batch), pe.Err)
+			batch = failed
+			continue
+		}
+		s.logger.WarnF("Retry send: attempt %d | err %v", attempt, err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && !s.wait(attempt) {
+			return false
+		}
+		err := s.output.Flush()
+		if err == nil {
+			break
 		}
+		s.logger.WarnF("Retry flush: attempt %d | err %v", attempt, err)
+	}
+
+	s.logger.InfoF("Sent %d rows", count)
+
+	now := time.Now()
+	s.state.sent(now)
+	for _, name := range sentSources {
+		s.sourceState(name).sent(now)
+	}
+	return true
+}
+
+// Pause before next attempt, false if app is stopping
+func (s *Sender) wait(attempt int) bool {
+	d := time.Duration(attempt*2) * time.Second
+	if d > time.Minute {
+		d = time.Minute
+	}
+	select {
+	case <-s.exit:
+		return false
+	case <-time.After(d):
+		return true
 	}
 }
@@ -0,0 +1,268 @@
+package app
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Source is one log dir with own settings. Sources are set by [source "name"]
+// sections, entries of [logs] and [techlog] are sources with global settings.
+type Source struct {
+	Name     string
+	Path     string
+	Format   string
+	Loc      *time.Location
+	MinLevel string
+	Include  []sourceFilter
+	Exclude  []sourceFilter
+	Outputs  []string
+	Index    string
+
+	// Found in cluster registry, not set in config
+	discovered bool
+}
+
+// Field of message matched by glob pattern, like Событие:_$Session$_.*
+type sourceFilter struct {
+	Field   string
+	Pattern string
+}
+
+// Formats of sources
+const (
+	formatEventLog = "eventlog"
+	formatTechLog  = "techlog"
+)
+
+// Sources of all sections, names are unique as they name checkpoints
+func (a *App) sourceConfigs(cfg *ini.File) (list []*Source, err error) {
+	list = a.readSources(cfg, func(name string, e error) {
+		if err == nil {
+			err = fmt.Errorf("source %s: %v", name, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Sources which are set correctly, every wrong one is passed to fail
+// and others are read further
+func (a *App) readSources(cfg *ini.File, fail func(name string, err error)) (list []*Source) {
+
+	main := cfg.Section("main")
+	names := make(map[string]bool)
+	add := func(s *Source) {
+		if names[s.Name] {
+			fail(s.Name, fmt.Errorf("duplicate name"))
+			return
+		}
+		names[s.Name] = true
+		list = append(list, s)
+	}
+
+	for _, section := range cfg.Sections() {
+		name := section.Name()
+		if !strings.HasPrefix(name, "source ") {
+			continue
+		}
+		name = strings.Trim(strings.TrimPrefix(name, "source "), `" `)
+		if !section.Key("enabled").MustBool(true) {
+			continue
+		}
+		s, err := a.sectionSource(cfg, section, name)
+		if err != nil {
+			fail(name, err)
+			continue
+		}
+		add(s)
+	}
+
+	// Flat lists with settings of [main]
+	flat := []struct {
+		section string
+		format  string
+	}{
+		{"logs", formatEventLog},
+		{"techlog", formatTechLog},
+	}
+	for _, f := range flat {
+		for _, key := range cfg.Section(f.section).Keys() {
+			s := &Source{
+				Name:     key.Name(),
+				Path:     key.String(),
+				Format:   f.format,
+				MinLevel: main.Key("msg_level").MustString("debug"),
+			}
+			var err error
+			if s.Loc, err = a.sourceLocation(cfg, s.Name); err != nil {
+				fail(s.Name, err)
+				continue
+			}
+			add(s)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return
+}
+
+// Source of [source "name"] section, not set settings are taken from [main]
+func (a *App) sectionSource(cfg *ini.File, section *ini.Section, name string) (s *Source, err error) {
+
+	main := cfg.Section("main")
+	s = &Source{
+		Name:     name,
+		Path:     section.Key("path").String(),
+		Format:   section.Key("format").MustString(formatEventLog),
+		MinLevel: section.Key("msg_level").MustString(main.Key("msg_level").MustString("debug")),
+		Outputs:  section.Key("outputs").Strings(","),
+		Index:    section.Key("index").String(),
+	}
+	if s.Path == "" {
+		return nil, fmt.Errorf("path is not set")
+	}
+	if s.Format != formatEventLog && s.Format != formatTechLog {
+		return nil, fmt.Errorf("unknown format %q", s.Format)
+	}
+	if zone := section.Key("timezone").String(); zone != "" {
+		s.Loc, err = loadLocation(zone)
+	} else {
+		s.Loc, err = a.sourceLocation(cfg, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Include, err = parseSourceFilters(section.Key("include").Strings(",")); err != nil {
+		return nil, err
+	}
+	if s.Exclude, err = parseSourceFilters(section.Key("exclude").Strings(",")); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseSourceFilters(items []string) (list []sourceFilter, err error) {
+	for _, item := range items {
+		i := strings.IndexByte(item, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("filter %q is not field:pattern", item)
+		}
+		f := sourceFilter{Field: strings.TrimSpace(item[:i]), Pattern: strings.TrimSpace(item[i+1:])}
+		if !isMessageField(f.Field) {
+			return nil, fmt.Errorf("filter %q: message has no field %s", item, f.Field)
+		}
+		if _, err := path.Match(f.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("filter %q: %v", item, err)
+		}
+		list = append(list, f)
+	}
+	return
+}
+
+// Exported field of message, as it is sent to outputs
+func isMessageField(name string) bool {
+	field, ok := reflect.TypeOf(Message{}).FieldByName(name)
+	return ok && field.PkgPath == ""
+}
+
+func (f sourceFilter) match(m *Message) bool {
+	v := reflect.ValueOf(m).Elem().FieldByName(f.Field)
+	ok, _ := path.Match(f.Pattern, fmt.Sprint(v.Interface()))
+	return ok
+}
+
+// Current settings of source, they are replaced on reload
+func (a *App) source(name string) *Source {
+	a.sourcesMu.RLock()
+	defer a.sourcesMu.RUnlock()
+	return a.sources[name]
+}
+
+func (a *App) setSource(src *Source) {
+	a.sourcesMu.Lock()
+	a.sources[src.Name] = src
+	a.sourcesMu.Unlock()
+}
+
+func (a *App) deleteSource(name string) {
+	a.sourcesMu.Lock()
+	delete(a.sources, name)
+	a.sourcesMu.Unlock()
+	a.stateMu.Lock()
+	delete(a.sourceStates, name)
+	a.stateMu.Unlock()
+}
+
+// Reader has to be restarted if dir or the way it is read changed, other
+// settings are applied in place
+func (s *Source) sameReader(o *Source) bool {
+	return s.Path == o.Path && s.Format == o.Format && s.Loc.String() == o.Loc.String()
+}
+
+// Message is sent if its level is not lower than msg_level, it matches one of
+// include filters and none of exclude filters
+func (s *Source) allow(m *Message) bool {
+	if s == nil {
+		return true
+	}
+	if !levelAllowed(s.MinLevel, m.Level) {
+		return false
+	}
+	if len(s.Include) > 0 {
+		found := false
+		for _, f := range s.Include {
+			if f.match(m) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, f := range s.Exclude {
+		if f.match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Messages of source go to all outputs if outputs are not set
+func (s *Source) routed(output string) bool {
+	if s == nil || len(s.Outputs) == 0 {
+		return true
+	}
+	for _, name := range s.Outputs {
+		if name == output {
+			return true
+		}
+	}
+	return false
+}
+
+// Outputs of sources must be set by [output "name"] sections
+func checkSourceOutputs(sources []*Source, outputs []OutputConfig) error {
+	known := make(map[string]bool, len(outputs))
+	for _, oc := range outputs {
+		known[oc.Name] = true
+	}
+	for _, src := range sources {
+		for _, name := range src.Outputs {
+			if !known[name] {
+				return fmt.Errorf("source %s: unknown output %q", src.Name, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/moskvorechie/log1c/spool"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var (
+	metricSpoolDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log1c_spool_depth",
+		Help: "Сколько сообщений в очереди ждут отправки",
+	},
+		[]string{"server", "output"},
+	)
+	metricSpoolBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log1c_spool_bytes",
+		Help: "Размер очереди на диске",
+	},
+		[]string{"server"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricSpoolDepth)
+	prometheus.MustRegister(metricSpoolBytes)
+}
+
+func (a *App) openSpool() (err error) {
+	section := a.cfg.Section("spool")
+	a.queue, err = spool.Open(spool.Config{
+		Dir:          a.root + section.Key("path").MustString("spool"),
+		SegmentBytes: section.Key("segment_bytes").MustInt64(16 * 1024 * 1024),
+		MaxBytes:     section.Key("max_bytes").MustInt64(1024 * 1024 * 1024),
+		SyncInterval: section.Key("sync_interval").MustDuration(time.Second),
+	})
+	return
+}
+
+// Move messages from readers to disk queue, reader position moves when message is on disk
+func (a *App) runSpool() {
+
+	defer a.wg.Done()
+
+	a.logger.Info("Spool start")
+	defer a.logger.Info("Spool stop")
+
+	full := false
+	for m := range a.mess {
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			a.logger.FatalError(err)
+		}
+
+		for {
+			err = a.queue.Append(data, m.ack)
+			if err != spool.ErrFull {
+				break
+			}
+
+			// Readers wait until senders free space
+			if !full {
+				a.logger.WarnF("Spool is full, %d bytes", a.queue.Bytes())
+				full = true
+			}
+			select {
+			case <-a.exit:
+			case <-time.After(time.Second):
+				continue
+			}
+			break
+		}
+
+		if err != nil {
+			a.logger.ErrorF("Spool append error: %v", err)
+			m.fail()
+			continue
+		}
+		if full {
+			a.logger.Info("Spool has free space")
+			full = false
+		}
+		a.sourceState(m.Source).event(m.ДатаВремя)
+
+		metricSpoolBytes.WithLabelValues(a.name).Set(float64(a.queue.Bytes()))
+	}
+}
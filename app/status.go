@@ -0,0 +1,340 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/moskvorechie/log1c/spool"
+	"github.com/rs/zerolog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State of source reader shown by /status, positions are set by reader,
+// times by spool and senders
+type sourceState struct {
+	mu        sync.Mutex
+	files     map[string]filePosition
+	lastEvent time.Time
+	lastSent  time.Time
+	lastError string
+	errorTime time.Time
+}
+
+// Current file of reader, techlog has one for every process dir
+type filePosition struct {
+	path   string
+	offset int64
+}
+
+// State of sender shown by /status
+type outputState struct {
+	mu        sync.Mutex
+	typ       string
+	queue     *spool.Consumer
+	open      bool
+	lastSent  time.Time
+	lastError string
+	errorTime time.Time
+}
+
+type statusResponse struct {
+	App        string         `json:"app"`
+	Started    time.Time      `json:"started"`
+	Ready      bool           `json:"ready"`
+	SpoolBytes int64          `json:"spool_bytes"`
+	Sources    []sourceStatus `json:"sources"`
+	Outputs    []outputStatus `json:"outputs"`
+}
+
+type sourceStatus struct {
+	Name          string       `json:"name"`
+	Path          string       `json:"path"`
+	Format        string       `json:"format"`
+	Discovered    bool         `json:"discovered"`
+	Files         []fileStatus `json:"files"`
+	Lag           int64        `json:"lag"`
+	LastEvent     *time.Time   `json:"last_event"`
+	LastSent      *time.Time   `json:"last_sent"`
+	Queue         uint64       `json:"queue"`
+	LastError     string       `json:"last_error,omitempty"`
+	LastErrorTime *time.Time   `json:"last_error_time,omitempty"`
+}
+
+// Offset and size are bytes, for .lgd they are row numbers
+type fileStatus struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Lag    int64  `json:"lag"`
+}
+
+type outputStatus struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Open          bool       `json:"open"`
+	Queue         uint64     `json:"queue"`
+	LastSent      *time.Time `json:"last_sent"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// State of source, it is kept while source is restarted by reload
+func (a *App) sourceState(name string) *sourceState {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	st, ok := a.sourceStates[name]
+	if !ok {
+		st = &sourceState{files: make(map[string]filePosition)}
+		a.sourceStates[name] = st
+	}
+	return st
+}
+
+func (a *App) outputState(name string) *outputState {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	st, ok := a.outputStates[name]
+	if !ok {
+		st = &outputState{}
+		a.outputStates[name] = st
+	}
+	return st
+}
+
+func (a *App) deleteOutputState(name string) {
+	a.stateMu.Lock()
+	delete(a.outputStates, name)
+	a.stateMu.Unlock()
+}
+
+// Position of reader in file, key is process dir for techlog and empty for event log
+func (s *sourceState) position(key, path string, offset int64) {
+	s.mu.Lock()
+	s.files[key] = filePosition{path: path, offset: offset}
+	s.mu.Unlock()
+}
+
+func (s *sourceState) event(t time.Time) {
+	s.mu.Lock()
+	if t.After(s.lastEvent) {
+		s.lastEvent = t
+	}
+	s.mu.Unlock()
+}
+
+func (s *sourceState) sent(t time.Time) {
+	s.mu.Lock()
+	s.lastSent = t
+	s.mu.Unlock()
+}
+
+// Errors of reader are kept by hook of its logger
+func (s *sourceState) hook() zerolog.Hook {
+	return zerolog.HookFunc(func(e *zerolog.Event, level zerolog.Level, msg string) {
+		if level < zerolog.ErrorLevel {
+			return
+		}
+		s.mu.Lock()
+		s.lastError, s.errorTime = msg, time.Now()
+		s.mu.Unlock()
+	})
+}
+
+// Retries of sender are warnings, they are kept too
+func (o *outputState) hook() zerolog.Hook {
+	return zerolog.HookFunc(func(e *zerolog.Event, level zerolog.Level, msg string) {
+		if level < zerolog.WarnLevel {
+			return
+		}
+		o.mu.Lock()
+		o.lastError, o.errorTime = msg, time.Now()
+		o.mu.Unlock()
+	})
+}
+
+func (o *outputState) opened() {
+	o.mu.Lock()
+	o.open = true
+	o.mu.Unlock()
+}
+
+func (o *outputState) sent(t time.Time) {
+	o.mu.Lock()
+	o.lastSent = t
+	o.mu.Unlock()
+}
+
+// Service runs and outputs are opened, senders retry until output is available
+func (a *App) readiness() (ready bool, reason string) {
+	select {
+	case <-a.exit:
+		return false, "app is stopping"
+	default:
+	}
+	if atomic.LoadInt32(&a.started) == 0 {
+		return false, "app is starting"
+	}
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	for name, o := range a.outputStates {
+		o.mu.Lock()
+		open := o.open
+		o.mu.Unlock()
+		if !open {
+			return false, fmt.Sprintf("output %s is not open", name)
+		}
+	}
+	return true, "ready"
+}
+
+// GET /healthz answers while process serves requests
+func (a *App) healthHandler(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// GET /readyz is 503 until service started and all outputs are open
+func (a *App) readyHandler(w http.ResponseWriter, req *http.Request) {
+	ready, reason := a.readiness()
+	if !ready {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, reason)
+}
+
+// GET /status shows position, lag, times of last event and send, queue and
+// last error of every source and output
+func (a *App) statusHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a.status()); err != nil {
+		a.logger.LogError(err)
+	}
+}
+
+func (a *App) status() (st statusResponse) {
+
+	st.App = a.name
+	st.Started = a.startTime
+	st.Ready, _ = a.readiness()
+	st.SpoolBytes = a.queue.Bytes()
+	st.Sources = []sourceStatus{}
+	st.Outputs = []outputStatus{}
+
+	a.sourcesMu.RLock()
+	sources := make([]*Source, 0, len(a.sources))
+	for _, src := range a.sources {
+		sources = append(sources, src)
+	}
+	a.sourcesMu.RUnlock()
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Name < sources[j].Name
+	})
+
+	// Outputs
+	a.stateMu.Lock()
+	outputs := make(map[string]*outputState, len(a.outputStates))
+	for name, o := range a.outputStates {
+		outputs[name] = o
+	}
+	a.stateMu.Unlock()
+	depth := make(map[string]uint64, len(outputs))
+	for name, o := range outputs {
+		o.mu.Lock()
+		out := outputStatus{
+			Name:      name,
+			Type:      o.typ,
+			Open:      o.open,
+			LastSent:  timeOrNil(o.lastSent),
+			LastError: o.lastError,
+		}
+		if o.lastError != "" {
+			out.LastErrorTime = timeOrNil(o.errorTime)
+		}
+		queue := o.queue
+		o.mu.Unlock()
+		if queue != nil {
+			out.Queue = queue.Depth()
+		}
+		depth[name] = out.Queue
+		st.Outputs = append(st.Outputs, out)
+	}
+	sort.Slice(st.Outputs, func(i, j int) bool {
+		return st.Outputs[i].Name < st.Outputs[j].Name
+	})
+
+	// Sources, queue is depth of the slowest output of source
+	for _, src := range sources {
+		ss := sourceStatus{
+			Name:       src.Name,
+			Path:       src.Path,
+			Format:     src.Format,
+			Discovered: src.discovered,
+			Files:      []fileStatus{},
+		}
+		for name, d := range depth {
+			if src.routed(name) && d > ss.Queue {
+				ss.Queue = d
+			}
+		}
+
+		state := a.sourceState(src.Name)
+		state.mu.Lock()
+		ss.LastEvent = timeOrNil(state.lastEvent)
+		ss.LastSent = timeOrNil(state.lastSent)
+		ss.LastError = state.lastError
+		if state.lastError != "" {
+			ss.LastErrorTime = timeOrNil(state.errorTime)
+		}
+		files := make([]filePosition, 0, len(state.files))
+		for _, f := range state.files {
+			files = append(files, f)
+		}
+		state.mu.Unlock()
+
+		// Size is taken now, reader could be waiting for changes
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].path < files[j].path
+		})
+		for _, f := range files {
+			if f.path == "" {
+				continue
+			}
+			fs := fileStatus{Path: f.path, Offset: f.offset}
+			fs.Size = fileSize(f.path)
+			if fs.Size > fs.Offset {
+				fs.Lag = fs.Size - fs.Offset
+			}
+			ss.Lag += fs.Lag
+			ss.Files = append(ss.Files, fs)
+		}
+		st.Sources = append(st.Sources, ss)
+	}
+
+	return
+}
+
+// Size of .lgp and techlog files, last row of .lgd
+func fileSize(path string) int64 {
+	if isLGD(path) {
+		row, _ := lgdLastRow(path)
+		return row
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
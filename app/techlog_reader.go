@@ -0,0 +1,374 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/moskvorechie/log1c/techlog"
+	"github.com/moskvorechie/logs"
+	"gopkg.in/ini.v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reads technological journal, every process writes own dir like rphost_1234 with hourly files
+type TechLogReader struct {
+	wg      *sync.WaitGroup
+	logger  logs.Log
+	cfg     *ini.File
+	exit    chan bool
+	name    string
+	path    string
+	app     *App
+	cpDir   string
+	procs   map[string]*techLogProc
+	watcher *Watcher
+	loc     *time.Location
+	state   *sourceState
+}
+
+// Position in files of one process dir
+type techLogProc struct {
+	dir  string
+	file string
+	pos  int64
+	cp   *checkpointStore
+}
+
+func (r *TechLogReader) Run() {
+
+	defer func() {
+		if rc := recover(); rc != nil {
+			r.logger.Error("Fatal stack: \n" + string(debug.Stack()))
+			r.logger.FatalF("Recovered Fatal %v", rc)
+		}
+	}()
+
+	defer r.wg.Done()
+
+	// Set app to logger, errors are shown by /status
+	r.state = r.app.sourceState(r.name)
+	r.logger.SetCustomLogger(r.logger.Logger().With().Str("log_name", r.name).Logger().Hook(r.state.hook()))
+
+	// Start & stop log
+	r.logger.Info("TechLogReader start")
+	defer r.logger.Info("TechLogReader stop")
+
+	appName := r.cfg.Section("main").Key("app").String()
+	r.procs = make(map[string]*techLogProc)
+	defer r.saveCheckpoint()
+
+	// Wake on new process dirs and writes to them
+	r.watcher = r.app.newWatcher(r.path)
+	defer r.watcher.Close()
+
+	// Dirs existing on start are read from the end
+	r.scan(false)
+
+	for {
+		rescan, ok := r.watcher.Wait(r.exit)
+		if !ok {
+			return
+		}
+
+		// Metric read time >
+		tReadDurStart := time.Now()
+
+		// New process dirs are read from the beginning
+		if rescan {
+			r.scan(true)
+		}
+		for _, p := range r.sortedProcs() {
+			r.read(p, rescan)
+			r.state.position(p.dir, p.file, p.pos)
+			select {
+			case <-r.exit:
+				return
+			default:
+			}
+		}
+
+		r.saveCheckpoint()
+
+		// Metric read time <
+		metricReadFileDur.WithLabelValues(appName).Set(time.Now().Sub(tReadDurStart).Seconds())
+	}
+}
+
+// Find process dirs, position is restored from checkpoint or set to the end of the newest file
+func (r *TechLogReader) scan(fromStart bool) {
+
+	dirs, err := ioutil.ReadDir(r.path)
+	if err != nil {
+		r.logger.LogError(err)
+		return
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() || r.procs[d.Name()] != nil {
+			continue
+		}
+		p := &techLogProc{
+			dir: filepath.Join(r.path, d.Name()),
+			cp:  newCheckpointStore(filepath.Join(r.cpDir, r.name+"."+d.Name()+".json")),
+		}
+
+		files := r.listFiles(p.dir)
+
+		cp, ok, err := p.cp.load()
+		if err != nil {
+			r.logger.LogError(err)
+		}
+		if ok {
+			if err := cp.verify(); err != nil {
+				r.logger.WarnF("Checkpoint skipped: %v", err)
+				ok = false
+			}
+		}
+		switch {
+		case ok:
+			p.file, p.pos = cp.Path, cp.Offset
+			r.logger.InfoF("Resume from %s at %d", cp.Path, cp.Offset)
+		case fromStart || len(files) == 0:
+
+			// First file is taken by read when it appears
+		default:
+			p.file = files[len(files)-1]
+			if stat, err := os.Stat(p.file); err == nil {
+				p.pos = stat.Size()
+			}
+		}
+		r.procs[d.Name()] = p
+		r.state.position(p.dir, p.file, p.pos)
+		if err := r.watcher.Add(p.dir); err != nil {
+			r.logger.WarnF("Watch %s error: %v", p.dir, err)
+		}
+	}
+}
+
+func (r *TechLogReader) sortedProcs() []*techLogProc {
+	list := make([]*techLogProc, 0, len(r.procs))
+	for _, p := range r.procs {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].dir < list[j].dir
+	})
+	return list
+}
+
+// Hourly files of process dir in order of creation
+func (r *TechLogReader) listFiles(dir string) (list []string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, ff := range files {
+		if !ff.Mode().IsRegular() || strings.ToLower(filepath.Ext(ff.Name())) != ".log" {
+			continue
+		}
+		if _, err := techlog.FileTime(ff.Name(), r.loc); err != nil {
+			continue
+		}
+		list = append(list, filepath.Join(dir, ff.Name()))
+	}
+	sort.Strings(list)
+	return
+}
+
+// Read current file of process and files created after it
+func (r *TechLogReader) read(p *techLogProc, rescan bool) {
+	if p.file == "" {
+		files := r.listFiles(p.dir)
+		if len(files) == 0 {
+			return
+		}
+		p.file, p.pos = files[0], 0
+	}
+	for {
+		p.pos = r.readFile(p, p.file, p.pos)
+		if !rescan {
+			return
+		}
+
+		var next string
+		for _, f := range r.listFiles(p.dir) {
+			if filepath.Base(f) > filepath.Base(p.file) {
+				next = f
+				break
+			}
+		}
+		if next == "" {
+			return
+		}
+
+		// Drain events written to old file before rotation
+		p.pos = r.readFile(p, p.file, p.pos)
+		if stat, err := os.Stat(p.file); err == nil && stat.Size() > p.pos {
+			r.logger.WarnF("Unfinished event skipped in %s at %d", p.file, p.pos)
+		}
+		p.file, p.pos = next, 0
+
+		select {
+		case <-r.exit:
+			return
+		default:
+		}
+	}
+}
+
+func (r *TechLogReader) readFile(p *techLogProc, path string, pos int64) int64 {
+
+	// Files are removed by 1C after history period
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pos
+	}
+	if err != nil {
+		r.logger.LogError(err)
+		return pos
+	}
+	defer file.Close()
+
+	base, err := techlog.FileTime(path, r.loc)
+	if err != nil {
+		r.logger.LogError(err)
+		return pos
+	}
+	if _, err := file.Seek(pos, io.SeekStart); err != nil {
+		r.logger.LogError(err)
+		return pos
+	}
+	identity, identitySize, err := fileIdentity(file, identityLen)
+	if err != nil {
+		r.logger.LogError(err)
+		return pos
+	}
+
+	h := sha256.New()
+	h.Write([]byte(path))
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+
+	dec := techlog.NewDecoder(file, base)
+	dec.SetOffset(pos)
+	for {
+		select {
+		case <-r.exit:
+			return pos
+		default:
+		}
+
+		e, err := dec.Decode()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pos
+		}
+		if _, ok := err.(*techlog.SyntaxError); !ok && err != nil {
+			r.logger.LogError(err)
+			return pos
+		}
+		pos = dec.Offset()
+		r.state.position(p.dir, path, pos)
+
+		cp := Checkpoint{
+			Path:        path,
+			Offset:      pos,
+			Identity:    identity,
+			IdentityLen: identitySize,
+			RecordHash:  recordHash(dec.Raw()),
+			RecordLen:   int64(len(dec.Raw())),
+		}
+
+		if err != nil {
+			r.logger.ErrorF("Parse event error: %v: %s", err, dec.Raw())
+			p.cp.skip(cp)
+			continue
+		}
+
+		m := r.prepareMessage(e, p, string(dec.Raw()))
+		h := sha256.New()
+		h.Write([]byte(hash + strconv.FormatInt(pos, 10)))
+		m.ID = fmt.Sprintf("%x", h.Sum(nil))
+
+		if !m.Allow {
+			p.cp.skip(cp)
+			continue
+		}
+		m.store = p.cp
+		m.mark = p.cp.track(cp)
+
+		select {
+		case <-r.exit:
+			return pos
+		case r.app.mess <- m:
+		}
+	}
+}
+
+// Common properties go to fields of event log, all of them are kept in Свойства
+func (r *TechLogReader) prepareMessage(e *techlog.Event, p *techLogProc, raw string) (m Message) {
+
+	m.Log = "techlog"
+	m.ДатаВремя = e.Time
+	m.ДатаВремяUTC = e.Time.UTC()
+	m.Событие = e.Name
+	m.Длительность = e.Duration.Microseconds()
+	m.Свойства = e.Map()
+	m.СыраяСтрока = strings.TrimRight(raw, "\r\n")
+
+	m.Пользователь = e.Get("Usr")
+	m.Компьютер = e.Get("t:computerName")
+	m.Приложение = e.Get("t:applicationName")
+	m.Соединение = e.Get("t:connectID")
+	m.Сеанс = e.Get("SessionID")
+	m.Комментарий = e.Get("Descr")
+
+	// Dir name is process name and pid
+	m.Процесс = filepath.Base(p.dir)
+	if i := strings.LastIndexByte(m.Процесс, '_'); i > 0 {
+		m.ПроцессИд, _ = strconv.ParseInt(m.Процесс[i+1:], 10, 64)
+		m.Процесс = m.Процесс[:i]
+	}
+
+	m.Level = "info"
+	switch e.Name {
+	case "EXCP", "EXCPCNTX", "QERR":
+		m.Level = "error"
+	case "TTIMEOUT", "TDEADLOCK", "ATTN":
+		m.Level = "warning"
+	}
+
+	m.NameDB = e.Get("p:processName")
+	if m.NameDB == "" {
+		m.NameDB = r.name
+	}
+	m.App = r.app.name
+	m.Folder = p.dir
+	m.Source = r.name
+	m.Allow = r.app.source(r.name).allow(&m)
+
+	return
+}
+
+// Records of stopped reader could be still on the way to spool
+func (r *TechLogReader) drainCheckpoint(timeout time.Duration) bool {
+	ok := true
+	for _, p := range r.procs {
+		ok = p.cp.drain(timeout) && ok
+	}
+	return ok
+}
+
+func (r *TechLogReader) saveCheckpoint() {
+	for _, p := range r.procs {
+		if err := p.cp.save(); err != nil {
+			r.logger.LogError(err)
+		}
+	}
+}
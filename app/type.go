@@ -1,45 +1,26 @@
 package app
 
-import "time"
-
-type Row struct {
-	startedMain      bool
-	dataStarted      bool
-	dataOpenBrackets int
-
-	DataSymbol string
-	DataRow    string
-
-	Date        time.Time
-	Transaction string
-	Row         int
-	User        int
-	PC          int
-	App         int
-	Conn        int
-	Event       int
-	Level       string
-	Comment     string
-	Meta        int
-	Message     string
-	Server      int
-	Port1       int
-	Port2       int
-	Session     int
-}
+import (
+	"github.com/moskvorechie/log1c/lgp"
+	"time"
+)
 
 type Message struct {
 	ID       string
 	Allow    bool
 	Level    string
 	App      string
+	Source   string
 	NameDB   string
 	Instance string
+	Log      string
 
 	ДатаВремя          time.Time
+	ДатаВремяUTC       time.Time
 	СтатусТранзакции   string
 	НомерТранзакции    string
 	ПользовательИд     int64
+	ПользовательUUID   string
 	Пользователь       string
 	Компьютер          string
 	КомпьютерИд        int64
@@ -51,7 +32,9 @@ type Message struct {
 	Комментарий        string
 	Метаданные         string
 	МетаданныеИд       int64
+	МетаданныеUUID     string
 	Данные             string
+	ДанныеЗначение     *lgp.Value `json:",omitempty"`
 	Представление      string
 	Сервер             string
 	СерверИд           int64
@@ -61,4 +44,27 @@ type Message struct {
 	СтатусТранзакцииИд string
 	СыраяСтрока        string
 	Folder             string
+
+	// Technological journal
+	Длительность int64             `json:",omitempty"`
+	Процесс      string            `json:",omitempty"`
+	ПроцессИд    int64             `json:",omitempty"`
+	Свойства     map[string]string `json:",omitempty"`
+
+	store *checkpointStore
+	mark  *checkpointMark
+}
+
+// Message delivered, reader position can move forward
+func (m Message) ack() {
+	if m.store != nil {
+		m.store.ack(m.mark)
+	}
+}
+
+// Message lost, reader position must stay before it
+func (m Message) fail() {
+	if m.store != nil {
+		m.store.fail(m.mark)
+	}
 }
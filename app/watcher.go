@@ -0,0 +1,109 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// Readers wake not more often than minWake, 1C writes records in bursts
+const minWake = 300 * time.Millisecond
+
+// Watcher wakes reader when files of watched dirs change, without file system
+// notifications it wakes reader every interval
+type Watcher struct {
+	interval time.Duration
+	events   chan struct{}
+	mu       sync.Mutex
+	changed  bool
+	last     time.Time
+	sys      sysWatcher
+}
+
+// Notifications of platform, see watcher_linux.go
+type sysWatcher interface {
+	add(dir string) error
+	close() error
+}
+
+// Watcher for dirs by [main] watch (auto or poll) and watch_interval
+func (a *App) newWatcher(dirs ...string) *Watcher {
+
+	section := a.config().Section("main")
+	w := &Watcher{
+		interval: section.Key("watch_interval").MustDuration(10 * time.Second),
+		events:   make(chan struct{}, 1),
+	}
+	if section.Key("watch").MustString("auto") == "poll" {
+		return w
+	}
+
+	sys, err := newSysWatcher(w)
+	if err != nil {
+		a.logger.WarnF("File notifications are not available, poll every %s: %v", w.interval, err)
+		return w
+	}
+	w.sys = sys
+	for _, dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			a.logger.WarnF("Watch %s error, poll every %s: %v", dir, w.interval, err)
+		}
+	}
+	return w
+}
+
+// Add dir to notifications, it is polled anyway
+func (w *Watcher) Add(dir string) error {
+	if w.sys == nil {
+		return nil
+	}
+	return w.sys.add(dir)
+}
+
+// Called by platform watcher, changed is true if files were created or removed
+func (w *Watcher) notify(changed bool) {
+	w.mu.Lock()
+	w.changed = w.changed || changed
+	w.mu.Unlock()
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+// Wait blocks until files change or interval passes, rescan is true if list
+// of files could change. It returns ok false on exit.
+func (w *Watcher) Wait(exit chan bool) (rescan bool, ok bool) {
+
+	timer := time.NewTimer(w.interval)
+	defer timer.Stop()
+	select {
+	case <-exit:
+		return false, false
+	case <-w.events:
+	case <-timer.C:
+		rescan = true
+	}
+
+	if d := minWake - time.Since(w.last); d > 0 {
+		select {
+		case <-exit:
+			return false, false
+		case <-time.After(d):
+		}
+	}
+	w.last = time.Now()
+
+	w.mu.Lock()
+	rescan = rescan || w.changed || w.sys == nil
+	w.changed = false
+	w.mu.Unlock()
+
+	return rescan, true
+}
+
+func (w *Watcher) Close() error {
+	if w.sys == nil {
+		return nil
+	}
+	return w.sys.close()
+}
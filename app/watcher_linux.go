@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package app
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"unsafe"
+)
+
+// Notifications through inotify
+type inotifyWatcher struct {
+	fd   int
+	file *os.File
+}
+
+const (
+	inotifyWrite  = unix.IN_MODIFY | unix.IN_CLOSE_WRITE
+	inotifyChange = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+)
+
+func newSysWatcher(w *Watcher) (sysWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	// Non blocking fd is read through runtime poller, so Close stops reading
+	iw := &inotifyWatcher{fd: fd, file: os.NewFile(uintptr(fd), "inotify")}
+	go iw.run(w)
+	return iw, nil
+}
+
+func (iw *inotifyWatcher) add(dir string) error {
+	_, err := unix.InotifyAddWatch(iw.fd, dir, inotifyWrite|inotifyChange)
+	return err
+}
+
+func (iw *inotifyWatcher) run(w *Watcher) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := iw.file.Read(buf)
+		if err != nil {
+			return
+		}
+		changed := false
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			if e.Mask&(inotifyChange|unix.IN_Q_OVERFLOW) != 0 {
+				changed = true
+			}
+			off += unix.SizeofInotifyEvent + int(e.Len)
+		}
+		w.notify(changed)
+	}
+}
+
+func (iw *inotifyWatcher) close() error {
+	return iw.file.Close()
+}
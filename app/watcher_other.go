@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package app
+
+import "errors"
+
+func newSysWatcher(w *Watcher) (sysWatcher, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,195 @@
+package bracket
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const maxDepth = 256
+
+type SyntaxError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bracket: %s at offset %d", e.Msg, e.Offset)
+}
+
+// Decoder reads top-level records from a stream one by one. Everything
+// outside of records (file header, commas, line breaks) is skipped.
+type Decoder struct {
+	r       *bufio.Reader
+	off     int64
+	start   int64
+	end     int64
+	inRec   bool
+	raw     []byte
+	atom    []byte
+	keepRaw bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:       bufio.NewReaderSize(r, 64*1024),
+		keepRaw: true,
+	}
+}
+
+// Parse decodes a single record from string
+func Parse(s string) (*Node, error) {
+	return NewDecoder(strings.NewReader(s)).Decode()
+}
+
+// KeepRaw switches collecting of raw record text returned by Raw
+func (d *Decoder) KeepRaw(keep bool) {
+	d.keepRaw = keep
+}
+
+// Decode returns next complete record. It returns io.EOF if stream ended
+// between records and io.ErrUnexpectedEOF if it ended inside one, in that
+// case the record can be read again later from Start offset.
+func (d *Decoder) Decode() (*Node, error) {
+
+	// Find record start
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == '{' {
+			break
+		}
+	}
+	d.start = d.off - 1
+	d.raw = append(d.raw[:0], '{')
+	d.inRec = true
+	defer func() { d.inRec = false }()
+
+	n, err := d.list(1)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.end = d.off
+
+	return n, nil
+}
+
+// Start returns offset of the last decoded record beginning
+func (d *Decoder) Start() int64 {
+	return d.start
+}
+
+// Offset returns offset right after the last decoded record
+func (d *Decoder) Offset() int64 {
+	return d.end
+}
+
+// Raw returns text of the last decoded record, valid until next Decode
+func (d *Decoder) Raw() []byte {
+	return d.raw
+}
+
+func (d *Decoder) list(depth int) (*Node, error) {
+	if depth > maxDepth {
+		return nil, &SyntaxError{Offset: d.off, Msg: "nesting too deep"}
+	}
+	n := &Node{Kind: List}
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case '}':
+			return n, nil
+		case ',', ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			item, err := d.list(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			n.Items = append(n.Items, item)
+		case '"':
+			item, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			n.Items = append(n.Items, item)
+		default:
+			item, err := d.ident(c)
+			if err != nil {
+				return nil, err
+			}
+			n.Items = append(n.Items, item)
+		}
+	}
+}
+
+func (d *Decoder) str() (*Node, error) {
+	d.atom = d.atom[:0]
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c != '"' {
+			d.atom = append(d.atom, c)
+			continue
+		}
+
+		// Doubled quote is escaped quote
+		next, err := d.r.Peek(1)
+		if err == nil && next[0] == '"' {
+			_, _ = d.readByte()
+			d.atom = append(d.atom, '"')
+			continue
+		}
+		return &Node{Kind: String, Value: string(d.atom)}, nil
+	}
+}
+
+func (d *Decoder) ident(first byte) (*Node, error) {
+	d.atom = append(d.atom[:0], first)
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case '{', '}', ',', '"', ' ', '\t', '\r', '\n':
+			d.unreadByte()
+			s := string(d.atom)
+			return &Node{Kind: atomKind(s), Value: s}, nil
+		}
+		d.atom = append(d.atom, c)
+	}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.off++
+	if d.inRec && d.keepRaw {
+		d.raw = append(d.raw, c)
+	}
+	return c, nil
+}
+
+func (d *Decoder) unreadByte() {
+	if d.r.UnreadByte() != nil {
+		return
+	}
+	d.off--
+	if d.inRec && d.keepRaw && len(d.raw) > 0 {
+		d.raw = d.raw[:len(d.raw)-1]
+	}
+}
@@ -0,0 +1,152 @@
+package bracket
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrings(t *testing.T) {
+
+	n, err := Parse("{\"a \"\"b\"\"\",\"\",\"\"\"\",\"{1,\r\n}\"}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`a "b"`, ``, `"`, "{1,\r\n}"}
+	if n.Len() != len(want) {
+		t.Fatalf("got %d items, want %d: %s", n.Len(), len(want), n)
+	}
+	for i, s := range want {
+		if item := n.At(i); item.Kind != String || item.Value != s {
+			t.Errorf("item %d is %s %q, want string %q", i, item.Kind, item.Value, s)
+		}
+	}
+
+	// Quotes are doubled back by String
+	if s := n.String(); s != "{\"a \"\"b\"\"\",\"\",\"\"\"\",\"{1,\r\n}\"}" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestDecodeAtoms(t *testing.T) {
+
+	n, err := Parse("{9d7c0015-5d00-0402-11e9-d6f6fa5b8a6a,123,-1.5,1.,-,24264b3ebe240,9d7c0015-5d00-0402-11e9-d6f6fa5b8a6z,\r\n\tI}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind  Kind
+		value string
+	}{
+		{GUID, "9d7c0015-5d00-0402-11e9-d6f6fa5b8a6a"},
+		{Number, "123"},
+		{Number, "-1.5"},
+		{Ident, "1."},
+		{Ident, "-"},
+		{Ident, "24264b3ebe240"},
+		{Ident, "9d7c0015-5d00-0402-11e9-d6f6fa5b8a6z"},
+		{Ident, "I"},
+	}
+	if n.Len() != len(want) {
+		t.Fatalf("got %d items, want %d: %s", n.Len(), len(want), n)
+	}
+	for i, w := range want {
+		if item := n.At(i); item.Kind != w.kind || item.Value != w.value {
+			t.Errorf("item %d is %s %q, want %s %q", i, item.Kind, item.Value, w.kind, w.value)
+		}
+	}
+}
+
+func TestDecodeDepth(t *testing.T) {
+
+	nested := func(depth int) string {
+		return strings.Repeat("{", depth) + "1" + strings.Repeat("}", depth)
+	}
+
+	n, err := Parse(nested(maxDepth))
+	if err != nil {
+		t.Fatalf("depth %d: %v", maxDepth, err)
+	}
+	for i := 1; i < maxDepth; i++ {
+		n = n.At(0)
+	}
+	if n.At(0).Str() != "1" {
+		t.Errorf("deepest item is %s", n)
+	}
+
+	_, err = Parse(nested(maxDepth + 1))
+	var se *SyntaxError
+	if !errors.As(err, &se) {
+		t.Fatalf("depth %d: got %v, want SyntaxError", maxDepth+1, err)
+	}
+	if se.Offset != maxDepth+1 {
+		t.Errorf("error offset %d, want %d", se.Offset, maxDepth+1)
+	}
+}
+
+func TestDecodeOffsets(t *testing.T) {
+
+	head := "1CV8LOG(ver 2.0)\r\n\r\n"
+	first := "{1,\"}\"}"
+	second := "{2,{3}}"
+	partial := "{4,\"{"
+	s := head + first + ",\r\n" + second + ",\r\n" + partial
+
+	d := NewDecoder(strings.NewReader(s))
+	if _, err := d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Start() != int64(len(head)) || d.Offset() != int64(len(head+first)) {
+		t.Errorf("first record at %d-%d", d.Start(), d.Offset())
+	}
+	if string(d.Raw()) != first {
+		t.Errorf("raw %q, want %q", d.Raw(), first)
+	}
+
+	if _, err := d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	end := int64(strings.Index(s, second) + len(second))
+	if string(d.Raw()) != second || d.Offset() != end {
+		t.Errorf("second record %q ends at %d, want %d", d.Raw(), d.Offset(), end)
+	}
+
+	// Offset stays after the last complete record, Start is where incomplete one begins
+	if _, err := d.Decode(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if d.Offset() != end {
+		t.Errorf("offset %d, want %d", d.Offset(), end)
+	}
+	if d.Start() != int64(len(s)-len(partial)) {
+		t.Errorf("start %d, want %d", d.Start(), len(s)-len(partial))
+	}
+
+	// Completed record is read from Start
+	d = NewDecoder(strings.NewReader(s[d.Start():] + "\"}\r\n"))
+	n, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.At(1).Str() != "{" {
+		t.Errorf("unexpected record %s", n)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+// Text of record is not collected, dictionary reader does not need it
+func TestDecodeWithoutRaw(t *testing.T) {
+	d := NewDecoder(strings.NewReader("{1},{2}"))
+	d.KeepRaw(false)
+	for i := 0; i < 2; i++ {
+		if _, err := d.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Raw()) > 1 {
+			t.Errorf("raw %q is kept", d.Raw())
+		}
+	}
+}
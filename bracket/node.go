@@ -0,0 +1,163 @@
+// Package bracket parses the 1C bracket syntax used by .lgp and .lgf files:
+// nested {…} lists of quoted strings (quotes doubled inside), numbers, GUIDs
+// and bare identifiers.
+package bracket
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Kind uint8
+
+const (
+	List Kind = iota
+	String
+	Number
+	GUID
+	Ident
+)
+
+func (k Kind) String() string {
+	switch k {
+	case List:
+		return "list"
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case GUID:
+		return "guid"
+	case Ident:
+		return "ident"
+	}
+	return "unknown"
+}
+
+// Node is one value of a record tree. Lists keep children in Items,
+// everything else keeps the unquoted text in Value.
+type Node struct {
+	Kind  Kind
+	Value string
+	Items []*Node
+}
+
+// Len returns count of list items, nil safe
+func (n *Node) Len() int {
+	if n == nil {
+		return 0
+	}
+	return len(n.Items)
+}
+
+// At returns list item or nil if it does not exist
+func (n *Node) At(i int) *Node {
+	if n == nil || i < 0 || i >= len(n.Items) {
+		return nil
+	}
+	return n.Items[i]
+}
+
+// Str returns scalar value, nil safe
+func (n *Node) Str() string {
+	if n == nil {
+		return ""
+	}
+	return n.Value
+}
+
+func (n *Node) Int() (int64, error) {
+	if n == nil {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseInt(n.Value, 10, 64)
+}
+
+func (n *Node) IsList() bool {
+	return n != nil && n.Kind == List
+}
+
+// String serializes node back to bracket syntax
+func (n *Node) String() string {
+	var sb strings.Builder
+	n.write(&sb)
+	return sb.String()
+}
+
+func (n *Node) write(sb *strings.Builder) {
+	if n == nil {
+		return
+	}
+	switch n.Kind {
+	case List:
+		sb.WriteByte('{')
+		for i, item := range n.Items {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			item.write(sb)
+		}
+		sb.WriteByte('}')
+	case String:
+		sb.WriteByte('"')
+		sb.WriteString(strings.ReplaceAll(n.Value, `"`, `""`))
+		sb.WriteByte('"')
+	default:
+		sb.WriteString(n.Value)
+	}
+}
+
+func atomKind(s string) Kind {
+	if isNumber(s) {
+		return Number
+	}
+	if isGUID(s) {
+		return GUID
+	}
+	return Ident
+}
+
+func isNumber(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return false
+	}
+	dot := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '.' && !dot && i > 0 && i < len(s)-1 {
+			dot = true
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isGUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isHex(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
@@ -4,11 +4,14 @@ go 1.14
 
 require (
 	4d63.com/tz v1.1.0
+	github.com/golang/snappy v0.0.1
 	github.com/moskvorechie/go-svc v1.1.3
 	github.com/moskvorechie/logs v1.4.0
 	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/zerolog v1.19.0
 	github.com/smartystreets/goconvey v1.6.4 // indirect
-	golang.org/x/sys v0.0.0-20200810151505-1b9f1253b3ed // indirect
+	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c
+	google.golang.org/protobuf v1.23.0
 	gopkg.in/ini.v1 v1.57.0
+	modernc.org/sqlite v1.10.0
 )
@@ -0,0 +1,118 @@
+package lgp
+
+import (
+	"fmt"
+	"github.com/moskvorechie/log1c/bracket"
+	"io"
+)
+
+// ItemType is type of dictionary record, the first field of it
+type ItemType int64
+
+const (
+	TypeUser ItemType = iota + 1
+	TypeComputer
+	TypeApp
+	TypeEvent
+	TypeMetadata
+	TypeServer
+	TypePort1
+	TypePort2
+)
+
+// Item of dictionary, users and metadata objects have UUID
+type Item struct {
+	Code int64
+	UUID string
+	Name string
+}
+
+// Dictionary maps codes of records to names, records of .lgf are
+// {type,[uuid,]name,code}, data separators and their values are kept by
+// their types too
+type Dictionary struct {
+	items map[ItemType]map[int64]Item
+}
+
+func NewDictionary() *Dictionary {
+	return &Dictionary{items: make(map[ItemType]map[int64]Item)}
+}
+
+// LoadDictionary reads whole .lgf file, records with wrong fields are skipped
+func LoadDictionary(r io.Reader) (*Dictionary, error) {
+	d := NewDictionary()
+	if _, err := d.ReadRecords(r, nil); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ReadRecords adds complete records of r. Offset is count of bytes of r up to
+// the end of the last complete record, not count of bytes read: unfinished
+// record at the end is not added, so dictionary which 1C keeps appending is
+// read again from that offset. Records with wrong fields are not added and
+// passed to skip with error of Add, skip can be nil.
+func (d *Dictionary) ReadRecords(r io.Reader, skip func(rec *bracket.Node, err error)) (offset int64, err error) {
+	dec := bracket.NewDecoder(r)
+	dec.KeepRaw(false)
+	for {
+		rec, err := dec.Decode()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		if err := d.Add(rec); err != nil && skip != nil {
+			skip(rec, err)
+		}
+		offset = dec.Offset()
+	}
+}
+
+// Add record of .lgf
+func (d *Dictionary) Add(rec *bracket.Node) error {
+	if rec.Len() < 3 {
+		return fmt.Errorf("dictionary record is too short: %s", rec)
+	}
+	typ, err1 := rec.At(0).Int()
+	code, err2 := rec.At(rec.Len() - 1).Int()
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("dictionary record has no type or code: %s", rec)
+	}
+	item := Item{Code: code, Name: rec.At(rec.Len() - 2).Str()}
+	if rec.Len() > 3 && rec.At(1).Kind == bracket.GUID {
+		item.UUID = rec.At(1).Str()
+	}
+	d.Set(ItemType(typ), item)
+	return nil
+}
+
+// Set adds or replaces item, it is used for dictionaries of .lgd
+func (d *Dictionary) Set(typ ItemType, item Item) {
+	m, ok := d.items[typ]
+	if !ok {
+		m = make(map[int64]Item)
+		d.items[typ] = m
+	}
+	m[item.Code] = item
+}
+
+// Get returns item by type and code
+func (d *Dictionary) Get(typ ItemType, code int64) (Item, bool) {
+	item, ok := d.items[typ][code]
+	return item, ok
+}
+
+// Name returns name of item, empty if it is absent
+func (d *Dictionary) Name(typ ItemType, code int64) string {
+	return d.items[typ][code].Name
+}
+
+// Len returns count of items of all types
+func (d *Dictionary) Len() (n int) {
+	for _, m := range d.items {
+		n += len(m)
+	}
+	return
+}
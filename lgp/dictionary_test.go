@@ -0,0 +1,53 @@
+package lgp
+
+import (
+	"bytes"
+	"github.com/moskvorechie/log1c/bracket"
+	"io/ioutil"
+	"testing"
+)
+
+// Fixture ends with record which is still being written
+func TestDictionaryReadRecords(t *testing.T) {
+
+	data, err := ioutil.ReadFile("testdata/1Cv8.lgf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDictionary()
+	var skipped []string
+	offset, err := d.ReadRecords(bytes.NewReader(data), func(rec *bracket.Node, err error) {
+		skipped = append(skipped, rec.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(skipped) != 1 || skipped[0] != "{9}" {
+		t.Errorf("skipped %v, want {9}", skipped)
+	}
+	if last := bytes.LastIndexByte(data, '{'); offset != int64(bytes.LastIndexByte(data[:last], '}')+1) {
+		t.Errorf("offset %d is not end of last complete record", offset)
+	}
+	if d.Len() != 7 {
+		t.Errorf("got %d items, want 7", d.Len())
+	}
+
+	user, _ := d.Get(TypeUser, 1)
+	if user.Name != "Admin" || user.UUID != "9d7c0015-5d00-0402-11e9-d6f6fa5b8a6a" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if name := d.Name(TypeEvent, 2); name != "_$Data$_.Update" {
+		t.Errorf("event 2 is %q", name)
+	}
+
+	// The rest of file is read from offset when 1C finishes record
+	data = append(data, "}"...)
+	if _, err := d.ReadRecords(bytes.NewReader(data[offset:]), nil); err != nil {
+		t.Fatal(err)
+	}
+	if name := d.Name(TypePort1, 1); name != "1560" {
+		t.Errorf("port is %q", name)
+	}
+}
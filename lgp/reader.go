@@ -0,0 +1,62 @@
+package lgp
+
+import (
+	"fmt"
+	"github.com/moskvorechie/log1c/bracket"
+	"io"
+)
+
+// RecordError is returned for record with valid syntax and wrong fields,
+// reading can be continued after it
+type RecordError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("lgp: %v at offset %d", e.Err, e.Offset)
+}
+
+// Reader reads records of .lgp file, header of file is skipped
+type Reader struct {
+	dec  *bracket.Decoder
+	base int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: bracket.NewDecoder(r)}
+}
+
+// SetOffset tells reader offset of r in file, offsets of records are counted from it
+func (r *Reader) SetOffset(off int64) {
+	r.base = off
+}
+
+// Read returns next complete record. It returns io.EOF if stream ended between
+// records and io.ErrUnexpectedEOF if record is still being written.
+func (r *Reader) Read() (*Record, error) {
+	n, err := r.dec.Decode()
+	if err != nil {
+		return nil, err
+	}
+	rec, err := Parse(n)
+	if err != nil {
+		return nil, &RecordError{Offset: r.Start(), Err: err}
+	}
+	return rec, nil
+}
+
+// Start returns offset of the last read record
+func (r *Reader) Start() int64 {
+	return r.base + r.dec.Start()
+}
+
+// Offset returns offset right after the last read record
+func (r *Reader) Offset() int64 {
+	return r.base + r.dec.Offset()
+}
+
+// Raw returns text of the last read record, valid until next Read
+func (r *Reader) Raw() []byte {
+	return r.dec.Raw()
+}
@@ -0,0 +1,203 @@
+package lgp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/moskvorechie/log1c/bracket"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Fixture has header, two records, record with bad date and one more record
+func readFixture(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("testdata/20200812000000.lgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReaderSkipsHeader(t *testing.T) {
+
+	data := readFixture(t)
+	r := NewReader(bytes.NewReader(data))
+
+	rec, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Date != "20200812120105" || rec.Level != "I" || rec.Comment != `Вход "Admin"` {
+		t.Errorf("unexpected first record %+v", rec)
+	}
+	if start := bytes.IndexByte(data, '{'); r.Start() != int64(start) {
+		t.Errorf("start %d, want %d after header", r.Start(), start)
+	}
+	if raw := string(r.Raw()); raw != string(data[r.Start():r.Offset()]) {
+		t.Errorf("raw %q does not match offsets", raw)
+	}
+
+	rec, err = r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Transaction != "24264b3ebe240-8f7" || rec.User != 1 || rec.Session != 2 || rec.Metadata != 5 {
+		t.Errorf("unexpected second record %+v", rec)
+	}
+	if rec.Comment != "ошибка\r\n{в строке}" {
+		t.Errorf("comment %q", rec.Comment)
+	}
+}
+
+func TestReaderRecordError(t *testing.T) {
+
+	r := NewReader(bytes.NewReader(readFixture(t)))
+	for i := 0; i < 2; i++ {
+		if _, err := r.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := r.Read()
+	var re *RecordError
+	if !errors.As(err, &re) {
+		t.Fatalf("got %v, want RecordError", err)
+	}
+	if re.Offset != r.Start() {
+		t.Errorf("error offset %d, want %d", re.Offset, r.Start())
+	}
+
+	// Reading goes on after bad record
+	rec, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Date != "20200812120107" {
+		t.Errorf("unexpected record after error %+v", rec)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReaderPartialRecord(t *testing.T) {
+
+	data := readFixture(t)
+	last := bytes.LastIndex(data, []byte("{20200812120107"))
+	r := NewReader(bytes.NewReader(data[:last+20]))
+
+	var end int64
+	for {
+		// Bad record is passed too, its offset is valid
+		_, err := r.Read()
+		var re *RecordError
+		if err != nil && !errors.As(err, &re) {
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+			}
+			break
+		}
+		end = r.Offset()
+	}
+	if end >= int64(last) {
+		t.Fatalf("offset %d is not before partial record at %d", end, last)
+	}
+
+	// Record is read again when it is written completely
+	r = NewReader(bytes.NewReader(data[end:]))
+	r.SetOffset(end)
+	rec, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Date != "20200812120107" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	if r.Start() != int64(last) || r.Offset() != int64(len(data)) {
+		t.Errorf("start %d offset %d, want %d and %d", r.Start(), r.Offset(), last, len(data))
+	}
+}
+
+func TestReaderSetOffset(t *testing.T) {
+
+	data := readFixture(t)
+	r := NewReader(bytes.NewReader(data))
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	first := r.Offset()
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	start, end := r.Start(), r.Offset()
+
+	// Offsets of reader opened in the middle of file are counted from SetOffset
+	r = NewReader(bytes.NewReader(data[first:]))
+	r.SetOffset(first)
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Start() != start || r.Offset() != end {
+		t.Errorf("start %d offset %d, want %d and %d", r.Start(), r.Offset(), start, end)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+
+	loc := time.FixedZone("MSK", 3*3600)
+	parse := func(s string) *Value {
+		n, err := bracket.Parse(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		return ParseValue(n, loc)
+	}
+
+	if v := parse(`{"U"}`); v == nil || v.Type != "U" || v.String != "" {
+		t.Errorf("U: %+v", v)
+	}
+	if v := parse(`{"S","a ""b"""}`); v.String != `a "b"` {
+		t.Errorf("S: %+v", v)
+	}
+	if v := parse(`{"N",-1.5}`); v.Number == nil || *v.Number != -1.5 {
+		t.Errorf("N: %+v", v)
+	}
+	if v := parse(`{"N",abc}`); v.Number != nil || v.String != "abc" {
+		t.Errorf("N not number: %+v", v)
+	}
+	if v := parse(`{"B",1}`); v.Bool == nil || !*v.Bool {
+		t.Errorf("B: %+v", v)
+	}
+	if v := parse(`{"B",0}`); v.Bool == nil || *v.Bool {
+		t.Errorf("B false: %+v", v)
+	}
+	if v := parse(`{"D",20200812120105}`); v.Date == nil || !v.Date.Equal(time.Date(2020, 8, 12, 12, 1, 5, 0, loc)) {
+		t.Errorf("D: %+v", v)
+	}
+	if v := parse(`{"D",2020}`); v.Date != nil || v.String != "2020" {
+		t.Errorf("D bad date: %+v", v)
+	}
+
+	// Parts of object ID are written in reverse order
+	v := parse(`{"R",136:9d7c00155d00040211e9d6f6fa5b8a6a}`)
+	if v.MetaID != 136 || v.UUID != "fa5b8a6a-d6f6-11e9-9d7c-00155d000402" {
+		t.Errorf("R: %+v", v)
+	}
+	if v := parse(`{"R",136:abc}`); v.MetaID != 136 || v.UUID != "abc" {
+		t.Errorf("R short: %+v", v)
+	}
+
+	// Containers without type letter are flattened, scalars are skipped
+	v = parse(`{"P",{1,{"S","a"},{{"N",2},{"B",1}}}}`)
+	if len(v.Items) != 3 || v.Items[0].String != "a" || *v.Items[1].Number != 2 || !*v.Items[2].Bool {
+		t.Errorf("P: %+v", v)
+	}
+
+	if v := parse(`{"X",1}`); v.String != `{"X",1}` {
+		t.Errorf("unknown type: %+v", v)
+	}
+	if v := parse(`{1}`); v != nil {
+		t.Errorf("no type letter: %+v", v)
+	}
+}
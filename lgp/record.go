@@ -0,0 +1,122 @@
+// Package lgp reads 1C event log in .lgp format: records of events written
+// in bracket syntax with codes of users, computers, events and other values,
+// which are resolved by dictionary of .lgf file.
+package lgp
+
+import (
+	"fmt"
+	"github.com/moskvorechie/log1c/bracket"
+	"time"
+)
+
+// Min count of fields in .lgp record
+const recordFields = 18
+
+// Record is one event, all references are codes of dictionary
+type Record struct {
+	Date              string
+	TransactionStatus string
+	Transaction       string
+	User              int64
+	Computer          int64
+	App               int64
+	Connection        int64
+	Event             int64
+	Level             string
+	Comment           string
+	Metadata          int64
+	Data              *bracket.Node
+	Presentation      string
+	Server            int64
+	Port1             int64
+	Port2             int64
+	Session           int64
+}
+
+// Parse makes record of decoded bracket list
+func Parse(n *bracket.Node) (r *Record, err error) {
+
+	if n.Len() < recordFields {
+		return nil, fmt.Errorf("record has %d fields, want at least %d", n.Len(), recordFields)
+	}
+
+	r = &Record{}
+	r.Date = n.At(0).Str()
+	if len(r.Date) != 14 {
+		return nil, fmt.Errorf("bad record date %q", r.Date)
+	}
+	r.TransactionStatus = n.At(1).Str()
+
+	tr := n.At(2)
+	if !tr.IsList() || tr.Len() < 2 {
+		return nil, fmt.Errorf("bad record transaction %s", tr)
+	}
+	r.Transaction = tr.At(0).Str() + "-" + tr.At(1).Str()
+
+	ids := []struct {
+		i int
+		v *int64
+	}{
+		{3, &r.User},
+		{4, &r.Computer},
+		{5, &r.App},
+		{6, &r.Connection},
+		{7, &r.Event},
+		{10, &r.Metadata},
+		{13, &r.Server},
+		{14, &r.Port1},
+		{15, &r.Port2},
+		{16, &r.Session},
+	}
+	for _, id := range ids {
+		*id.v, err = n.At(id.i).Int()
+		if err != nil {
+			return nil, fmt.Errorf("bad record field %d %q: %v", id.i, n.At(id.i).Str(), err)
+		}
+	}
+
+	r.Level = n.At(8).Str()
+	r.Comment = n.At(9).Str()
+	r.Data = n.At(11)
+	if !r.Data.IsList() {
+		return nil, fmt.Errorf("bad record data %s", r.Data)
+	}
+	r.Presentation = n.At(12).Str()
+
+	return r, nil
+}
+
+// Time of event, 1C writes local time of server without zone
+func (r *Record) Time(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation("20060102150405", r.Date, loc)
+}
+
+// LevelName returns level of record as info, warning, error or debug, empty for unknown one
+func LevelName(level string) string {
+	switch level {
+	case "I":
+		return "info"
+	case "W":
+		return "warning"
+	case "E":
+		return "error"
+	case "N":
+		return "debug"
+	}
+	return ""
+}
+
+// TransactionStatusName returns status of transaction as 1C shows it
+func TransactionStatusName(status string) string {
+	switch status {
+	case "N":
+		return "Отсутствует"
+	case "U":
+		return "Зафиксирована"
+	case "R":
+		return "Не завершена"
+	case "C":
+		return "Отменена"
+	}
+	return status
+}
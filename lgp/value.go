@@ -0,0 +1,92 @@
+package lgp
+
+import (
+	"github.com/moskvorechie/log1c/bracket"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value is decoded data of record, only fields of its type are set
+type Value struct {
+	Type   string     `json:"type"`
+	String string     `json:"string,omitempty"`
+	Number *float64   `json:"number,omitempty"`
+	Bool   *bool      `json:"bool,omitempty"`
+	Date   *time.Time `json:"date,omitempty"`
+	MetaID int64      `json:"meta_id,omitempty"`
+	UUID   string     `json:"uuid,omitempty"`
+	Items  []*Value   `json:"items,omitempty"`
+}
+
+// ParseValue decodes list with type letter first: {"U"}, {"S","text"}, {"N",1.5}, {"B",1},
+// {"D",20200812000000}, {"R",136:9d7c00155d00040211e9d6f6fa5b8a6a}, {"P",{...}}
+func ParseValue(n *bracket.Node, loc *time.Location) *Value {
+
+	if !n.IsList() || n.Len() == 0 || n.At(0).Kind != bracket.String {
+		return nil
+	}
+
+	d := &Value{Type: n.At(0).Str()}
+	v := n.At(1).Str()
+
+	switch d.Type {
+	case "U":
+	case "S":
+		d.String = v
+	case "N":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			d.Number = &f
+		} else {
+			d.String = v
+		}
+	case "B":
+		b := v == "1"
+		d.Bool = &b
+	case "D":
+		if t, err := time.ParseInLocation("20060102150405", v, loc); err == nil {
+			d.Date = &t
+		} else {
+			d.String = v
+		}
+	case "R":
+		d.MetaID, d.UUID = splitRef(v)
+	case "P":
+		d.Items = valueItems(n.At(1), loc)
+	default:
+		d.String = n.String()
+	}
+
+	return d
+}
+
+// Values of structure, lists without type letter are containers of values
+func valueItems(n *bracket.Node, loc *time.Location) (list []*Value) {
+	if !n.IsList() {
+		return
+	}
+	for _, item := range n.Items {
+		if !item.IsList() {
+			continue
+		}
+		if d := ParseValue(item, loc); d != nil {
+			list = append(list, d)
+			continue
+		}
+		list = append(list, valueItems(item, loc)...)
+	}
+	return
+}
+
+// Reference is metadata ID and object UUID, which 1C writes with parts in reverse order
+func splitRef(s string) (int64, string) {
+	meta, id := "", s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		meta, id = s[:i], s[i+1:]
+	}
+	metaID, _ := strconv.ParseInt(meta, 10, 64)
+	if len(id) != 32 {
+		return metaID, id
+	}
+	return metaID, id[24:32] + "-" + id[20:24] + "-" + id[16:20] + "-" + id[0:4] + "-" + id[4:16]
+}
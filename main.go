@@ -1,9 +1,11 @@
 package main
 
 import (
+	"flag"
 	"github.com/moskvorechie/go-svc/svc"
 	"github.com/moskvorechie/log1c/app"
 	"log"
+	"os"
 )
 
 type program struct {
@@ -12,8 +14,21 @@ type program struct {
 }
 
 func main() {
+
+	// Commands run in console and exit, without args it is service
+	if len(os.Args) > 1 && app.IsCommand(os.Args[1]) {
+		os.Exit(app.RunCommand(os.Args[1:]))
+	}
+
+	var opts app.Options
+	flag.BoolVar(&opts.Backfill, "backfill", false, "load all .lgp files of period before tailing")
+	flag.StringVar(&opts.BackfillFrom, "from", "", "backfill period start, YYYY-MM-DD or YYYY-MM-DD hh:mm:ss")
+	flag.StringVar(&opts.BackfillTo, "to", "", "backfill period end, YYYY-MM-DD or YYYY-MM-DD hh:mm:ss")
+	flag.BoolVar(&opts.BackfillTail, "tail", false, "tail logs after backfill")
+	flag.Parse()
+
 	prg := program{
-		svr: &app.App{},
+		svr: &app.App{Options: opts},
 	}
 	if err := svc.Run(&prg); err != nil {
 		log.Fatal(err)
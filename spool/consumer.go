@@ -0,0 +1,188 @@
+package spool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Consumer reads queue with own cursor, saved in <dir>/<name>.cursor
+type Consumer struct {
+	q      *Queue
+	name   string
+	path   string
+	read   Position
+	commit Position
+	file   *os.File
+	fileOf uint64
+}
+
+// Consumer with saved cursor, new one starts from the oldest record
+func (q *Queue) Consumer(name string) (c *Consumer, err error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if c, ok := q.consumers[name]; ok {
+		return c, nil
+	}
+
+	c = &Consumer{
+		q:    q,
+		name: name,
+		path: filepath.Join(q.cfg.Dir, name+cursorExt),
+	}
+
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &c.commit); err != nil {
+			return nil, err
+		}
+	}
+	err = nil
+
+	// Cursor is lost or its segment was removed
+	if c.commit.Seq > q.next {
+		c.commit = q.locate(0)
+	} else if q.segmentIndex(c.commit.Segment) < 0 {
+		c.commit = q.locate(c.commit.Seq)
+	}
+	c.read = c.commit
+
+	q.consumers[name] = c
+	return c, nil
+}
+
+// Read returns up to maxCount records or maxBytes of data. It waits for new
+// records until wait is passed or exit is closed, after first record is read
+// it waits only for filling the batch.
+func (c *Consumer) Read(maxCount int, maxBytes int, wait time.Duration, exit <-chan bool) (records [][]byte, pos Position, err error) {
+
+	deadline := time.After(wait)
+	size := 0
+	for {
+		c.q.mu.Lock()
+		if c.q.closed {
+			c.q.mu.Unlock()
+			return records, c.read, ErrClosed
+		}
+		for len(records) < maxCount && size < maxBytes && c.read.Seq < c.q.next {
+			data, err := c.next()
+			if err != nil {
+				c.q.mu.Unlock()
+				return records, c.read, err
+			}
+			records = append(records, data)
+			size += len(data)
+		}
+		notify := c.q.notify
+		c.q.mu.Unlock()
+
+		if len(records) >= maxCount || size >= maxBytes {
+			return records, c.read, nil
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return records, c.read, nil
+		case <-exit:
+			return records, c.read, nil
+		}
+	}
+}
+
+// Read record at cursor, must be called under lock
+func (c *Consumer) next() ([]byte, error) {
+	for {
+		i := c.q.segmentIndex(c.read.Segment)
+		if i < 0 {
+			c.read = c.q.locate(c.read.Seq)
+			i = c.q.segmentIndex(c.read.Segment)
+		}
+		s := c.q.segments[i]
+
+		// Segment is finished, go to the next one
+		if c.read.Offset >= s.size && i+1 < len(c.q.segments) {
+			c.read = Position{Seq: c.q.segments[i+1].first, Segment: c.q.segments[i+1].first}
+			continue
+		}
+
+		if c.file == nil || c.fileOf != s.first {
+			c.closeFile()
+			file, err := os.Open(s.path)
+			if err != nil {
+				return nil, err
+			}
+			c.file = file
+			c.fileOf = s.first
+		}
+
+		data, n, err := readRecord(c.file, c.read.Offset, true)
+		if err != nil {
+			return nil, err
+		}
+		c.read.Offset += n
+		c.read.Seq++
+		return data, nil
+	}
+}
+
+// RemoveConsumer drops consumer and its cursor, segments kept only for it are removed
+func (q *Queue) RemoveConsumer(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c, ok := q.consumers[name]
+	if !ok {
+		return nil
+	}
+	c.closeFile()
+	delete(q.consumers, name)
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.gc()
+	return nil
+}
+
+// Commit marks records before pos as delivered, they will not be read again after restart
+func (c *Consumer) Commit(pos Position) error {
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	c.commit = pos
+	if err := writeJSON(c.path, pos); err != nil {
+		return err
+	}
+	c.q.gc()
+	return nil
+}
+
+// Rewind returns cursor to last committed record, uncommitted records will be read again
+func (c *Consumer) Rewind() {
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	c.read = c.commit
+}
+
+// Depth is count of records not committed yet
+func (c *Consumer) Depth() uint64 {
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	return c.q.next - c.commit.Seq
+}
+
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+func (c *Consumer) closeFile() {
+	if c.file != nil {
+		_ = c.file.Close()
+		c.file = nil
+	}
+}
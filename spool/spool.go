@@ -0,0 +1,386 @@
+// Package spool is a durable queue on disk. Records are appended to segment
+// files, every named consumer reads them with its own cursor, segments are
+// removed when all consumers committed them.
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerLen = 8
+	maxRecord = 64 * 1024 * 1024
+	segExt    = ".seg"
+	cursorExt = ".cursor"
+)
+
+var (
+	ErrFull   = errors.New("spool: queue is full")
+	ErrClosed = errors.New("spool: queue is closed")
+)
+
+type Config struct {
+	Dir          string
+	SegmentBytes int64
+	MaxBytes     int64
+	SyncInterval time.Duration
+}
+
+// Position of record in queue
+type Position struct {
+	Seq     uint64
+	Segment uint64
+	Offset  int64
+}
+
+type segment struct {
+	first uint64
+	path  string
+	size  int64
+}
+
+type Queue struct {
+	cfg       Config
+	mu        sync.Mutex
+	segments  []*segment
+	active    *os.File
+	next      uint64
+	bytes     int64
+	pending   []func()
+	notify    chan struct{}
+	consumers map[string]*Consumer
+	closed    bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func Open(cfg Config) (q *Queue, err error) {
+
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = 16 * 1024 * 1024
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = time.Second
+	}
+	if err = os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return
+	}
+
+	q = &Queue{
+		cfg:       cfg,
+		notify:    make(chan struct{}),
+		consumers: make(map[string]*Consumer),
+		done:      make(chan struct{}),
+	}
+
+	// Existing segments
+	files, err := ioutil.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() || filepath.Ext(f.Name()) != segExt {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, &segment{
+			first: first,
+			path:  filepath.Join(cfg.Dir, f.Name()),
+			size:  f.Size(),
+		})
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].first < q.segments[j].first
+	})
+
+	if len(q.segments) == 0 {
+		if err = q.roll(); err != nil {
+			return nil, err
+		}
+	} else if err = q.recover(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range q.segments {
+		q.bytes += s.size
+	}
+
+	q.wg.Add(1)
+	go q.syncLoop()
+
+	return q, nil
+}
+
+// Count records of the last segment and cut broken tail left by crash
+func (q *Queue) recover() error {
+
+	last := q.segments[len(q.segments)-1]
+	file, err := os.OpenFile(last.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	var count uint64
+	var offset int64
+	for {
+		n, err := readRecordLen(file, offset)
+		if err != nil {
+			break
+		}
+		offset += n
+		count++
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	last.size = offset
+	q.active = file
+	q.next = last.first + count
+	return nil
+}
+
+// Start new segment from next record
+func (q *Queue) roll() error {
+	if q.active != nil {
+		if err := q.sync(); err != nil {
+			return err
+		}
+		if err := q.active.Close(); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(q.cfg.Dir, fmt.Sprintf("%020d%s", q.next, segExt))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	q.active = file
+	q.segments = append(q.segments, &segment{first: q.next, path: path})
+	return nil
+}
+
+// Append writes record, onSync is called when record is flushed to disk
+func (q *Queue) Append(data []byte, onSync func()) error {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+	if len(data) > maxRecord {
+		return fmt.Errorf("spool: record size %d is too big", len(data))
+	}
+
+	recLen := int64(headerLen + len(data))
+	if q.cfg.MaxBytes > 0 && q.bytes+recLen > q.cfg.MaxBytes {
+		return ErrFull
+	}
+
+	active := q.segments[len(q.segments)-1]
+	if active.size > 0 && active.size+recLen > q.cfg.SegmentBytes {
+		if err := q.roll(); err != nil {
+			return err
+		}
+		active = q.segments[len(q.segments)-1]
+	}
+
+	buf := make([]byte, recLen)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[headerLen:], data)
+	if _, err := q.active.Write(buf); err != nil {
+		return err
+	}
+
+	active.size += recLen
+	q.bytes += recLen
+	q.next++
+	if onSync != nil {
+		q.pending = append(q.pending, onSync)
+	}
+
+	// Wake up consumers
+	close(q.notify)
+	q.notify = make(chan struct{})
+
+	return nil
+}
+
+// Flush active segment and call waiting callbacks, must be called under lock
+func (q *Queue) sync() error {
+	if len(q.pending) == 0 {
+		return nil
+	}
+	if err := q.active.Sync(); err != nil {
+		return err
+	}
+	for _, f := range q.pending {
+		f()
+	}
+	q.pending = nil
+	return nil
+}
+
+func (q *Queue) syncLoop() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-time.After(q.cfg.SyncInterval):
+			q.mu.Lock()
+			_ = q.sync()
+			q.mu.Unlock()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	err := q.sync()
+	if cerr := q.active.Close(); err == nil {
+		err = cerr
+	}
+	for _, c := range q.consumers {
+		c.closeFile()
+	}
+	close(q.notify)
+	q.mu.Unlock()
+	q.wg.Wait()
+	return err
+}
+
+// Bytes on disk
+func (q *Queue) Bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// Remove segments committed by all consumers, must be called under lock
+func (q *Queue) gc() {
+	if len(q.consumers) == 0 {
+		return
+	}
+	var min uint64
+	first := true
+	for _, c := range q.consumers {
+		if first || c.commit.Seq < min {
+			min = c.commit.Seq
+			first = false
+		}
+	}
+	for len(q.segments) > 1 && q.segments[1].first <= min {
+		s := q.segments[0]
+		for _, c := range q.consumers {
+			if c.fileOf == s.first {
+				c.closeFile()
+			}
+		}
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		q.bytes -= s.size
+		q.segments = q.segments[1:]
+	}
+}
+
+func (q *Queue) segmentIndex(first uint64) int {
+	for i, s := range q.segments {
+		if s.first == first {
+			return i
+		}
+	}
+	return -1
+}
+
+// Position of segment start for seq, used when cursor segment was removed
+func (q *Queue) locate(seq uint64) Position {
+	for _, s := range q.segments {
+		if s.first >= seq {
+			return Position{Seq: s.first, Segment: s.first}
+		}
+	}
+	last := q.segments[len(q.segments)-1]
+	return Position{Seq: last.first, Segment: last.first}
+}
+
+// Read record at offset, returns record length with header
+func readRecordLen(file io.ReaderAt, offset int64) (int64, error) {
+	_, n, err := readRecord(file, offset, false)
+	return n, err
+}
+
+func readRecord(file io.ReaderAt, offset int64, keep bool) ([]byte, int64, error) {
+	var header [headerLen]byte
+	if _, err := file.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecord {
+		return nil, 0, fmt.Errorf("spool: bad record size %d at %d", size, offset)
+	}
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, offset+headerLen); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("spool: bad record checksum at %d", offset)
+	}
+	if !keep {
+		data = nil
+	}
+	return data, headerLen + int64(size), nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,327 @@
+package spool
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestQueue(t *testing.T, cfg Config) *Queue {
+	if cfg.Dir == "" {
+		dir, err := ioutil.TempDir("", "log1c-spool")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Dir = dir
+	}
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func appendRecords(t *testing.T, q *Queue, from, to int) {
+	for i := from; i < to; i++ {
+		if err := q.Append(record(i), nil); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+}
+
+// Records have the same size on disk
+const recordLen = headerLen + 39
+
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record %032d", i))
+}
+
+// Read all available records
+func readAll(t *testing.T, c *Consumer) ([][]byte, Position) {
+	list, pos, err := c.Read(1000, 1<<20, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list, pos
+}
+
+func checkRecords(t *testing.T, list [][]byte, from, to int) {
+	t.Helper()
+	if len(list) != to-from {
+		t.Fatalf("got %d records, want %d", len(list), to-from)
+	}
+	for i, data := range list {
+		if !bytes.Equal(data, record(from+i)) {
+			t.Errorf("record %d is %q, want %q", i, data, record(from+i))
+		}
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	list, err := filepath.Glob(filepath.Join(dir, "*"+segExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestAppendReadCommit(t *testing.T) {
+
+	q := openTestQueue(t, Config{})
+	defer os.RemoveAll(q.cfg.Dir)
+
+	c, err := q.Consumer("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	synced := 0
+	for i := 0; i < 5; i++ {
+		if err := q.Append(record(i), func() { synced++ }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, pos := readAll(t, c)
+	checkRecords(t, list, 0, 5)
+	if pos.Seq != 5 || c.Depth() != 5 {
+		t.Errorf("position %+v, depth %d", pos, c.Depth())
+	}
+
+	// Batch is limited by count
+	c.Rewind()
+	if list, _, _ := c.Read(2, 1<<20, 0, nil); len(list) != 2 {
+		t.Errorf("got %d records, want 2", len(list))
+	}
+
+	c.Rewind()
+	list, pos = readAll(t, c)
+	checkRecords(t, list, 0, 5)
+	if err := c.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if c.Depth() != 0 {
+		t.Errorf("depth %d after commit", c.Depth())
+	}
+
+	// Committed records are not read again
+	c.Rewind()
+	if list, _ := readAll(t, c); len(list) != 0 {
+		t.Errorf("got %d records after commit", len(list))
+	}
+
+	// Callbacks are called when records are flushed
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if synced != 5 {
+		t.Errorf("%d records synced, want 5", synced)
+	}
+	if err := q.Append(record(5), nil); err != ErrClosed {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
+
+func TestReadWaitsForAppend(t *testing.T) {
+
+	q := openTestQueue(t, Config{})
+	defer os.RemoveAll(q.cfg.Dir)
+	defer q.Close()
+
+	c, err := q.Consumer("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		if err := q.Append(record(0), nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	list, _, err := c.Read(1, 1<<20, 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, list, 0, 1)
+}
+
+func TestReopen(t *testing.T) {
+
+	q := openTestQueue(t, Config{SegmentBytes: 100})
+	dir := q.cfg.Dir
+	defer os.RemoveAll(dir)
+
+	a, _ := q.Consumer("a")
+	b, _ := q.Consumer("b")
+	appendRecords(t, q, 0, 5)
+	list, pos := readAll(t, a)
+	checkRecords(t, list, 0, 5)
+	if err := a.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if list, _, _ := b.Read(3, 1<<20, 0, nil); len(list) != 3 {
+		t.Fatalf("got %d records, want 3", len(list))
+	}
+	_, pos, _ = b.Read(0, 1<<20, 0, nil)
+	if err := b.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cursors are restored and numbering goes on
+	q = openTestQueue(t, Config{Dir: dir, SegmentBytes: 100})
+	defer q.Close()
+	a, _ = q.Consumer("a")
+	b, _ = q.Consumer("b")
+	appendRecords(t, q, 5, 6)
+	list, _ = readAll(t, a)
+	checkRecords(t, list, 5, 6)
+	list, _ = readAll(t, b)
+	checkRecords(t, list, 3, 6)
+	if b.Depth() != 3 {
+		t.Errorf("depth %d, want 3", b.Depth())
+	}
+}
+
+func TestTruncatedTail(t *testing.T) {
+
+	q := openTestQueue(t, Config{})
+	dir := q.cfg.Dir
+	defer os.RemoveAll(dir)
+	appendRecords(t, q, 0, 3)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash in the middle of record: header is written, data is not
+	path := segmentFiles(t, dir)[0]
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte{39, 0, 0, 0, 1, 2, 3, 4, 'r', 'e'}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	q = openTestQueue(t, Config{Dir: dir})
+	c, _ := q.Consumer("a")
+	appendRecords(t, q, 3, 4)
+	list, _ := readAll(t, c)
+	checkRecords(t, list, 0, 4)
+	if q.Bytes() != 4*recordLen {
+		t.Errorf("queue has %d bytes, want %d", q.Bytes(), 4*recordLen)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Record with wrong checksum is cut with everything after it
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-recordLen-1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "a"+cursorExt))
+
+	q = openTestQueue(t, Config{Dir: dir})
+	defer q.Close()
+	c, _ = q.Consumer("a")
+	list, _ = readAll(t, c)
+	checkRecords(t, list, 0, 2)
+}
+
+func TestSegmentsRemovedAfterCommit(t *testing.T) {
+
+	// Two records in segment
+	q := openTestQueue(t, Config{SegmentBytes: 100})
+	dir := q.cfg.Dir
+	defer os.RemoveAll(dir)
+	defer q.Close()
+
+	a, _ := q.Consumer("a")
+	b, _ := q.Consumer("b")
+	appendRecords(t, q, 0, 6)
+	if n := len(segmentFiles(t, dir)); n != 3 {
+		t.Fatalf("got %d segments, want 3", n)
+	}
+
+	// Segment is kept until slowest consumer commits it
+	_, pos := readAll(t, a)
+	if err := a.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(segmentFiles(t, dir)); n != 3 {
+		t.Errorf("got %d segments, want 3 while b has not committed", n)
+	}
+
+	list, _, _ := b.Read(4, 1<<20, 0, nil)
+	checkRecords(t, list, 0, 4)
+	_, pos, _ = b.Read(0, 1<<20, 0, nil)
+	if err := b.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Errorf("got %d segments, want 1", n)
+	}
+	if q.Bytes() != 2*recordLen {
+		t.Errorf("queue has %d bytes, want %d", q.Bytes(), 2*recordLen)
+	}
+
+	// Reading goes on in the last segment, new consumer starts from the oldest record
+	list, _ = readAll(t, b)
+	checkRecords(t, list, 4, 6)
+	c, _ := q.Consumer("c")
+	list, _ = readAll(t, c)
+	checkRecords(t, list, 4, 6)
+
+	// Removed consumer does not hold segments
+	appendRecords(t, q, 6, 8)
+	_, pos = readAll(t, a)
+	if err := a.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	_, pos = readAll(t, b)
+	if err := b.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.RemoveConsumer("c"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Errorf("got %d segments, want 1 after consumer is removed", n)
+	}
+}
+
+func TestFull(t *testing.T) {
+
+	// One record in segment, two records fit
+	q := openTestQueue(t, Config{SegmentBytes: 50, MaxBytes: 100})
+	defer os.RemoveAll(q.cfg.Dir)
+	defer q.Close()
+
+	c, _ := q.Consumer("a")
+	appendRecords(t, q, 0, 2)
+	if err := q.Append(record(2), nil); err != ErrFull {
+		t.Fatalf("got %v, want ErrFull", err)
+	}
+
+	// Space of committed segment is free again
+	list, _, _ := c.Read(1, 1<<20, 0, nil)
+	checkRecords(t, list, 0, 1)
+	_, pos, _ := c.Read(0, 1<<20, 0, nil)
+	if err := c.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, q, 2, 3)
+	list, _ = readAll(t, c)
+	checkRecords(t, list, 1, 3)
+}
@@ -0,0 +1,230 @@
+package techlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SyntaxError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("techlog: %s at offset %d", e.Msg, e.Offset)
+}
+
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// Decoder reads events of one file, base is hour of the file
+type Decoder struct {
+	r     *bufio.Reader
+	base  time.Time
+	off   int64
+	start int64
+	end   int64
+	raw   []byte
+}
+
+func NewDecoder(r io.Reader, base time.Time) *Decoder {
+	return &Decoder{
+		r:    bufio.NewReaderSize(r, 64*1024),
+		base: base,
+	}
+}
+
+// SetOffset tells decoder offset of reader in file, BOM is expected only at 0
+func (d *Decoder) SetOffset(off int64) {
+	d.off = off
+	d.start = off
+	d.end = off
+}
+
+// Decode returns next complete event. It returns io.EOF if stream ended
+// between events and io.ErrUnexpectedEOF if event is still being written.
+// On SyntaxError the broken event is skipped and Offset points after it.
+func (d *Decoder) Decode() (*Event, error) {
+
+	d.raw = d.raw[:0]
+	d.start = d.off
+	var quote, prev byte
+	var closing bool
+
+	for {
+		line, err := d.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+		if d.off == 0 && len(d.raw) == 0 && bytes.HasPrefix(line, bom) {
+			d.off += int64(len(bom))
+			d.start = d.off
+			line = line[len(bom):]
+		}
+		d.off += int64(len(line))
+
+		// Quoted values can contain line breaks, quote is closed by
+		// the next byte which is not a doubled quote
+		for _, c := range line {
+			if closing {
+				closing = false
+				if c == quote {
+					prev = c
+					continue
+				}
+				quote = 0
+			}
+			switch {
+			case quote == 0 && (c == '\'' || c == '"') && prev == '=':
+				quote = c
+			case quote != 0 && c == quote:
+				closing = true
+			}
+			prev = c
+		}
+		d.raw = append(d.raw, line...)
+
+		if err == io.EOF {
+			if len(bytes.TrimSpace(d.raw)) == 0 {
+				return nil, io.EOF
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if quote != 0 || len(line) == 0 || line[len(line)-1] != '\n' {
+			continue
+		}
+
+		// Empty lines between events
+		if len(bytes.TrimSpace(d.raw)) == 0 {
+			d.raw = d.raw[:0]
+			d.start = d.off
+			prev = 0
+			continue
+		}
+
+		d.end = d.off
+		return d.parse(strings.TrimRight(string(d.raw), "\r\n"))
+	}
+}
+
+// Start returns offset of the last decoded event beginning
+func (d *Decoder) Start() int64 {
+	return d.start
+}
+
+// Offset returns offset right after the last decoded event
+func (d *Decoder) Offset() int64 {
+	return d.end
+}
+
+// Raw returns text of the last decoded event, valid until next Decode
+func (d *Decoder) Raw() []byte {
+	return d.raw
+}
+
+func (d *Decoder) parse(s string) (*Event, error) {
+
+	bad := func(msg string) (*Event, error) {
+		return nil, &SyntaxError{Offset: d.start, Msg: msg}
+	}
+
+	// mm:ss.ffffff-duration
+	head, rest := cut(s, ',')
+	ts, dur := cut(head, '-')
+	mm, sec := cut(ts, ':')
+	ss, frac := cut(sec, '.')
+	if len(mm) != 2 || len(ss) != 2 || frac == "" || dur == "" {
+		return bad("bad event header")
+	}
+	m, err1 := strconv.Atoi(mm)
+	sc, err2 := strconv.Atoi(ss)
+	f, err3 := strconv.ParseInt(frac, 10, 64)
+	du, err4 := strconv.ParseInt(dur, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || len(frac) > 9 {
+		return bad("bad event header")
+	}
+
+	// Duration is written in units of time fraction, 1/10000 s in 8.2 and 1/1000000 s in 8.3
+	unit := time.Duration(1)
+	for i := len(frac); i < 9; i++ {
+		unit *= 10
+	}
+
+	e := &Event{
+		Time:     d.base.Add(time.Duration(m)*time.Minute + time.Duration(sc)*time.Second + time.Duration(f)*unit),
+		Duration: time.Duration(du) * unit,
+	}
+
+	var level string
+	e.Name, rest = cut(rest, ',')
+	level, rest = cut(rest, ',')
+	if e.Name == "" {
+		return bad("no event name")
+	}
+	if level != "" {
+		lv, err := strconv.Atoi(level)
+		if err != nil {
+			return bad("bad event level")
+		}
+		e.Level = lv
+	}
+
+	for rest != "" {
+		var p Prop
+		i := strings.IndexAny(rest, "=,")
+		if i < 0 {
+			p.Name, rest = rest, ""
+			e.Props = append(e.Props, p)
+			break
+		}
+		p.Name = rest[:i]
+		if rest[i] == ',' {
+			rest = rest[i+1:]
+			e.Props = append(e.Props, p)
+			continue
+		}
+		rest = rest[i+1:]
+
+		if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+			q := rest[0]
+			var b strings.Builder
+			j := 1
+			for ; j < len(rest); j++ {
+				if rest[j] != q {
+					b.WriteByte(rest[j])
+					continue
+				}
+				if j+1 < len(rest) && rest[j+1] == q {
+					b.WriteByte(q)
+					j++
+					continue
+				}
+				break
+			}
+			if j >= len(rest) {
+				return bad("unclosed quote in property " + p.Name)
+			}
+			p.Value = b.String()
+			rest = strings.TrimPrefix(rest[j+1:], ",")
+		} else {
+			p.Value, rest = cut(rest, ',')
+		}
+		e.Props = append(e.Props, p)
+	}
+
+	return e, nil
+}
+
+func cut(s string, sep byte) (string, string) {
+	if i := strings.IndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
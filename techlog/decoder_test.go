@@ -0,0 +1,104 @@
+package techlog
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeEvents(t *testing.T) {
+
+	base := time.Date(2020, 8, 12, 12, 0, 0, 0, time.UTC)
+	first := "05:01.123456-7,CALL,1,p:processName=base,Context='Форма.Вызов : Обработка\r\nстрока 2',Usr=Admin\r\n"
+	second := "05:02.000001-0,EXCP,2,Descr=\"He said \"\"hi\"\", ok\",Txt='it''s',Empty=\r\n"
+	broken := "not an event\r\n"
+	third := "05:03.5000-10,CONN,0\r\n"
+	partial := "05:04.000000-0,SDBL,3,Sql='SELECT\r\n"
+	s := string(bom) + first + "\r\n" + second + broken + third + partial
+
+	d := NewDecoder(strings.NewReader(s), base)
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BOM is not a part of event
+	if d.Start() != int64(len(bom)) || d.Offset() != int64(len(bom)+len(first)) {
+		t.Errorf("first event at %d-%d", d.Start(), d.Offset())
+	}
+	if string(d.Raw()) != first {
+		t.Errorf("raw %q, want %q", d.Raw(), first)
+	}
+	if !e.Time.Equal(base.Add(5*time.Minute+time.Second+123456*time.Microsecond)) || e.Duration != 7*time.Microsecond {
+		t.Errorf("time %s, duration %s", e.Time, e.Duration)
+	}
+	if e.Name != "CALL" || e.Level != 1 || len(e.Props) != 3 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e.Get("p:processName") != "base" || e.Get("Usr") != "Admin" {
+		t.Errorf("unexpected properties %+v", e.Props)
+	}
+	if v := e.Get("Context"); v != "Форма.Вызов : Обработка\r\nстрока 2" {
+		t.Errorf("multi-line value %q", v)
+	}
+
+	// Doubled quotes of both styles, empty line before event is skipped
+	if e, err = d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "EXCP" || len(e.Props) != 3 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if v := e.Get("Descr"); v != `He said "hi", ok` {
+		t.Errorf("double quoted value %q", v)
+	}
+	if v := e.Get("Txt"); v != "it's" {
+		t.Errorf("single quoted value %q", v)
+	}
+	if e.Props[2] != (Prop{Name: "Empty"}) {
+		t.Errorf("empty property %+v", e.Props[2])
+	}
+
+	// Broken event is reported and skipped
+	_, err = d.Decode()
+	var se *SyntaxError
+	if !errors.As(err, &se) {
+		t.Fatalf("got %v, want SyntaxError", err)
+	}
+	if at := int64(strings.Index(s, broken)); se.Offset != at || d.Offset() != at+int64(len(broken)) {
+		t.Errorf("error at %d, offset %d", se.Offset, d.Offset())
+	}
+
+	// Fraction of 8.2 is 1/10000 s
+	if e, err = d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Time.Equal(base.Add(5*time.Minute+3500*time.Millisecond)) || e.Duration != time.Millisecond || e.Name != "CONN" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	end := d.Offset()
+
+	// Offset stays after the last complete event, Start is where incomplete one begins
+	if _, err := d.Decode(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	start := int64(len(s) - len(partial))
+	if d.Offset() != end || d.Start() != start {
+		t.Errorf("offset %d, start %d, want %d, %d", d.Offset(), d.Start(), end, start)
+	}
+
+	// Completed event is read from Start
+	d = NewDecoder(strings.NewReader(s[start:]+"'\r\n"), base)
+	d.SetOffset(start)
+	if e, err = d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Get("Sql") != "SELECT\r\n" || d.Start() != start {
+		t.Errorf("unexpected event %+v at %d", e, d.Start())
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package techlog parses 1C technological journal files: events written as
+// mm:ss.ffffff-duration,NAME,level,prop=value,... into hourly files named
+// YYMMDDHH.log, property values with commas or line breaks are quoted.
+package techlog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Prop struct {
+	Name  string
+	Value string
+}
+
+type Event struct {
+	Time     time.Time
+	Duration time.Duration
+	Name     string
+	Level    int
+	Props    []Prop
+}
+
+// Get returns value of the first property with given name
+func (e *Event) Get(name string) string {
+	for _, p := range e.Props {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// Map returns properties by name, repeated properties are joined by line break
+func (e *Event) Map() map[string]string {
+	m := make(map[string]string, len(e.Props))
+	for _, p := range e.Props {
+		if v, ok := m[p.Name]; ok {
+			m[p.Name] = v + "\n" + p.Value
+			continue
+		}
+		m[p.Name] = p.Value
+	}
+	return m
+}
+
+// FileTime returns hour of file from its name YYMMDDHH.log
+func FileTime(path string, loc *time.Location) (time.Time, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	t, err := time.ParseInLocation("06010215", name, loc)
+	if err != nil {
+		return t, fmt.Errorf("techlog: file name %q is not YYMMDDHH", filepath.Base(path))
+	}
+	return t, nil
+}